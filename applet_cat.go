@@ -0,0 +1,6 @@
+//go:build !slim || applet_cat
+// +build !slim applet_cat
+
+package main
+
+import _ "gitlab.com/yarbelk/slimbox/lib/cat"
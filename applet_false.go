@@ -0,0 +1,6 @@
+//go:build !slim || applet_false
+// +build !slim applet_false
+
+package main
+
+import _ "gitlab.com/yarbelk/slimbox/lib/falsy"
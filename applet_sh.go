@@ -0,0 +1,6 @@
+//go:build !slim || applet_sh
+// +build !slim applet_sh
+
+package main
+
+import _ "gitlab.com/yarbelk/slimbox/lib/sh"
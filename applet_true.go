@@ -0,0 +1,6 @@
+//go:build !slim || applet_true
+// +build !slim applet_true
+
+package main
+
+import _ "gitlab.com/yarbelk/slimbox/lib/truthy"
@@ -0,0 +1,6 @@
+//go:build !slim || applet_wc
+// +build !slim applet_wc
+
+package main
+
+import _ "gitlab.com/yarbelk/slimbox/lib/wc"
@@ -1,7 +1,12 @@
 package main
 
-import "gitlab.com/yarbelk/slimbox/lib/falsy"
+import (
+	"os"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+	_ "gitlab.com/yarbelk/slimbox/lib/falsy"
+)
 
 func main() {
-	falsy.False()
+	os.Exit(lib.Run("false", os.Args[1:]))
 }
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+	_ "gitlab.com/yarbelk/slimbox/lib/sh"
+)
+
+func main() {
+	os.Exit(lib.Run("sh", os.Args[1:]))
+}
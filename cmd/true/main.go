@@ -1,7 +1,12 @@
 package main
 
-import "gitlab.com/yarbelk/slimbox/lib/truthy"
+import (
+	"os"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+	_ "gitlab.com/yarbelk/slimbox/lib/truthy"
+)
 
 func main() {
-	truthy.True()
+	os.Exit(lib.Run("true", os.Args[1:]))
 }
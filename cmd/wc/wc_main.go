@@ -1,32 +1,12 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
-	"github.com/spf13/pflag"
-	"gitlab.com/yarbelk/slimbox/lib/wc"
-)
-
-// I don't like the use of the wc.XFlag constants here: its too coupled
-var (
-	options      *pflag.FlagSet = pflag.NewFlagSet("wc", pflag.ContinueOnError)
-	lines        *bool          = options.BoolP(wc.LineFlag, "l", false, "Count newlines")
-	bytes        *bool          = options.BoolP(wc.ByteFlag, "c", false, "Count bytes")
-	words        *bool          = options.BoolP(wc.WordFlag, "w", false, "Count words")
-	characters   *bool          = options.BoolP(wc.CharFlag, "m", false, "Count characters")
-	printLongist *bool          = options.BoolP(wc.LongFlag, "L", false, "Print longest line length")
+	"gitlab.com/yarbelk/slimbox/lib"
+	_ "gitlab.com/yarbelk/slimbox/lib/wc"
 )
 
 func main() {
-	wcOptions := wc.Options{}
-	wcFS := wc.BindFlagSet(&wcOptions)
-	if err := wcFS.Parse(os.Args[1:]); err != nil {
-		fmt.Fprint(os.Stderr, err)
-		wcFS.Usage()
-	}
-	if err := wc.Main(wcOptions); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
+	os.Exit(lib.Run("wc", os.Args[1:]))
 }
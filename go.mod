@@ -1,10 +1,10 @@
 module gitlab.com/yarbelk/slimbox
 
-go 1.16
+go 1.22
 
 require (
+	github.com/klauspost/compress v1.18.0
+	github.com/rivo/uniseg v0.4.7
 	github.com/spf13/pflag v1.0.6-0.20210604193023-d5e0c0615ace
-	github.com/voxelbrain/goptions v0.0.0-20180630082107-58cddc247ea2
-	github.com/yarbelk/slimbox v0.0.0-20160219062232-acbcf00017d9
-	golang.org/x/text v0.3.6
+	github.com/ulikunitz/xz v0.5.15
 )
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+// installOptions for `slimbox --install DIR`
+type installOptions struct {
+	Dir      string
+	Hardlink bool
+	Force    bool
+}
+
+// install links every registered function in dir to binary, so it can be
+// called by name like busybox.  Existing entries are skipped unless Force is
+// set, in which case they are replaced.  Everything done is reported on
+// stdout, failures on stderr.
+func install(ctx *lib.Context, options installOptions, binary string) int {
+	link, verb := os.Symlink, "->"
+	if options.Hardlink {
+		link, verb = os.Link, "=>"
+	}
+
+	status := 0
+	for _, fn := range lib.RegisteredFunctions() {
+		name := filepath.Join(options.Dir, fn)
+		if _, err := os.Lstat(name); err == nil {
+			if !options.Force {
+				fmt.Fprintf(ctx.Stdout, "skipped '%s': already exists\n", name)
+				continue
+			}
+			if err := os.Remove(name); err != nil {
+				fmt.Fprintf(ctx.Stderr, "slimbox: %s\n", err)
+				status = 1
+				continue
+			}
+		}
+		if err := link(binary, name); err != nil {
+			fmt.Fprintf(ctx.Stderr, "slimbox: %s\n", err)
+			status = 1
+			continue
+		}
+		fmt.Fprintf(ctx.Stdout, "'%s' %s '%s'\n", name, verb, binary)
+	}
+	return status
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+func TestInstall(t *testing.T) {
+	if !lib.RegisteredFunctions().Contains("cat") {
+		t.Skip("cat isn't compiled in")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "slimbox")
+	if err := os.WriteFile(binary, []byte("#!/bin/true\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	existing := filepath.Join(dir, "cat")
+	if err := os.WriteFile(existing, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx := &lib.Context{Stdout: stdout, Stderr: stderr}
+	if status := install(ctx, installOptions{Dir: dir}, binary); status != 0 {
+		t.Fatalf("expected exit 0, actual %d: %s", status, stderr)
+	}
+
+	for _, fn := range lib.RegisteredFunctions() {
+		target, err := os.Readlink(filepath.Join(dir, fn))
+		if fn == "cat" {
+			if err == nil {
+				t.Errorf("cat should have been skipped, links to %s", target)
+			}
+			continue
+		}
+		if err != nil || target != binary {
+			t.Errorf("%s: expected link to %s, actual %q (%v)", fn, binary, target, err)
+		}
+	}
+	if !bytes.Contains(stdout.Bytes(), []byte("skipped '"+existing+"'")) {
+		t.Errorf("expected skip to be reported, actual %q", stdout)
+	}
+
+	stdout.Reset()
+	if status := install(ctx, installOptions{Dir: dir, Hardlink: true, Force: true}, binary); status != 0 {
+		t.Fatalf("expected exit 0, actual %d: %s", status, stderr)
+	}
+	binInfo, _ := os.Stat(binary)
+	for _, fn := range lib.RegisteredFunctions() {
+		info, err := os.Lstat(filepath.Join(dir, fn))
+		if err != nil || !os.SameFile(binInfo, info) {
+			t.Errorf("%s: expected hard link to %s (%v)", fn, binary, err)
+		}
+	}
+}
+
+// --install's one operand is DIR, wherever it is among the options
+func TestInstallFlags(t *testing.T) {
+	dir := t.TempDir()
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx := &lib.Context{Stdout: stdout, Stderr: stderr}
+	if status := slimbox(ctx, []string{"--install", "-H", "-f", dir}); status != 0 {
+		t.Fatalf("expected exit 0, actual %d: %s", status, stderr)
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binInfo, _ := os.Stat(binary)
+	for _, fn := range lib.RegisteredFunctions() {
+		info, err := os.Lstat(filepath.Join(dir, fn))
+		if err != nil || !os.SameFile(binInfo, info) {
+			t.Errorf("%s: expected hard link to %s (%v)", fn, binary, err)
+		}
+	}
+
+	for _, args := range [][]string{{"--install"}, {"--install", "-H"}, {"--install", dir, dir}} {
+		stderr.Reset()
+		if status := slimbox(ctx, args); status != lib.ExitUsage {
+			t.Errorf("%q: expected exit %d, actual %d", args, lib.ExitUsage, status)
+		}
+		if !strings.HasPrefix(stderr.String(), "slimbox: --install takes one DIR") {
+			t.Errorf("%q: unexpected error %q", args, stderr)
+		}
+	}
+}
@@ -2,126 +2,170 @@ package cat
 
 import (
 	"bufio"
-	"bytes"
-	"fmt"
 	"io"
+	"sync"
 )
 
 type CatOptions struct {
-	EoL       bool
-	Tabs      bool
-	Number    bool
-	Blank     bool
-	Help      bool
-	Files     []string
-	curNumber int
+	EoL         bool
+	Tabs        bool
+	Number      bool
+	Blank       bool
+	NonPrinting bool
+	Squeeze     bool
+	// All, NonPrintingEoL and NonPrintingTabs are the -A, -e and -t
+	// shorthands; RunCat turns them into the options they stand for
+	All             bool
+	NonPrintingEoL  bool
+	NonPrintingTabs bool
+	// Unbuffered is accepted for POSIX, and ignored: output isn't held back anyway
+	Unbuffered bool
+	// Decompress reads compressed files as what's in them; see
+	// lib/decompress
+	Decompress bool
+	// Transforms are extra stages run on each line before the ones the
+	// options above ask for
+	Transforms []Transform
+	Files      []string
+
+	// pipeline is built on the first Cat, and kept so numbering and
+	// squeezing carry on over the following files
+	pipeline Pipeline
 }
 
-type lineMutator func(io.Reader) ([]byte, error)
-
-func escapeTabs(inputStream io.Reader) ([]byte, error) {
-	bufferedReader := bufio.NewScanner(inputStream)
-	outputStream := new(bytes.Buffer)
-	bufferedReader.Split(bufio.ScanBytes)
-	for bufferedReader.Scan() {
-		aByte := bufferedReader.Bytes()
-		if aByte[0] != byte('\t') {
-			outputStream.Write(aByte)
-		} else {
-			outputStream.Write([]byte{'^', 'I'})
-		}
-	}
-	if err := bufferedReader.Err(); err != nil {
-		return nil, err
-	}
-	return outputStream.Bytes(), nil
+// NewCatOptions returns a pointer to a default CatOptions struct
+func NewCatOptions() *CatOptions {
+	return &CatOptions{}
+}
+
+// copyBufferSize is for when neither end of a raw copy can do better
+const copyBufferSize = 128 << 10
+
+var copyBuffers = sync.Pool{New: func() interface{} { return make([]byte, copyBufferSize) }}
+
+// raw reports if c leaves the bytes alone, so Cat can copy them straight through
+func (c *CatOptions) raw() bool {
+	return !c.EoL && !c.Tabs && !c.Number && !c.Blank && !c.NonPrinting && !c.Squeeze && len(c.Transforms) == 0
 }
 
-func (c *CatOptions) prependLineNumber(inputStream io.Reader) ([]byte, error) {
-	bufferedReader := bufio.NewScanner(inputStream)
-	outputStream := new(bytes.Buffer)
-	if !c.Blank {
-		numbers := fmt.Sprintf("%6d  ", c.curNumber)
-		c.curNumber++
-		outputStream.Write([]byte(numbers))
+// Pipeline is the stages c asks for, in the order they apply: squeezing,
+// then the per byte escapes, then numbering, so the numbers aren't escaped,
+// then marking the ends, so blank lines are still blank when numbering.
+// Each call makes new stages, counting from 1.
+func (c *CatOptions) Pipeline() Pipeline {
+	pipeline := make(Pipeline, 0, len(c.Transforms)+5)
+	pipeline = append(pipeline, c.Transforms...)
+	if c.Squeeze {
+		pipeline = append(pipeline, &SqueezeBlank{})
 	}
-	for bufferedReader.Scan() {
-		if c.Blank {
-			numbers := fmt.Sprintf("%6d  ", c.curNumber)
-			c.curNumber++
-			outputStream.Write([]byte(numbers))
-		}
-		outputStream.Write(bufferedReader.Bytes())
+	if c.NonPrinting {
+		pipeline = append(pipeline, ShowNonPrinting)
+	}
+	if c.Tabs {
+		pipeline = append(pipeline, ShowTabs)
+	}
+	if c.Number || c.Blank {
+		pipeline = append(pipeline, NewLineNumber(c.Blank))
 	}
-	if err := bufferedReader.Err(); err != nil {
-		return nil, err
+	if c.EoL {
+		pipeline = append(pipeline, ShowEnds)
 	}
-	return outputStream.Bytes(), nil
+	return pipeline
 }
 
-func appendEOL(inputStream io.Reader) ([]byte, error) {
-	bufferedReader := bufio.NewScanner(inputStream)
-	outputStream := new(bytes.Buffer)
-	for bufferedReader.Scan() {
-		outputStream.Write(bufferedReader.Bytes())
-		outputStream.Write([]byte{byte('$')})
-	}
-	if err := bufferedReader.Err(); err != nil {
-		return nil, err
+// readLine appends the next line from r, '\n' and all, to buf.  Lines can be
+// any length.
+func readLine(r *bufio.Reader, buf []byte) ([]byte, error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		buf = append(buf, chunk...)
+		if err != bufio.ErrBufferFull {
+			return buf, err
+		}
 	}
-	return outputStream.Bytes(), nil
 }
 
-// NewCatOptions returns a pointer to a default CatOptions struct, which means curNumber is 1
-func NewCatOptions() *CatOptions {
-	c := &CatOptions{}
-	c.curNumber = 1
-	return c
+// WriteError is Cat failing on its output rather than its input, so there is
+// no point going on to the next file
+type WriteError struct {
+	Err error
+}
 
+func (w *WriteError) Error() string { return w.Err.Error() }
+func (w *WriteError) Unwrap() error { return w.Err }
+
+// readSide reads from r, and keeps the error if that fails
+type readSide struct {
+	r   io.Reader
+	err error
 }
 
-func (c *CatOptions) Cat(originalInputStream io.Reader, outputStream io.Writer) error {
-	var (
-		line []byte
-		err  error
-	)
+func (r *readSide) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
 
-	bufferedReader := bufio.NewScanner(originalInputStream)
-	for bufferedReader.Scan() {
-		line = bufferedReader.Bytes()
-		if c.EoL {
-			line, err = appendEOL(bytes.NewBuffer(line))
-			if err != nil {
-				break
-			}
-		}
-		if c.Tabs {
-			line, err = escapeTabs(bytes.NewBuffer(line))
-			if err != nil {
-				break
-			}
+// Cat copies originalInputStream to outputStream, formatting it as c says.
+// With no formatting it is a plain io.Copy, so a regular file to an *os.File
+// gets the kernel to do it (copy_file_range, splice or sendfile on linux), and
+// everything else a large buffer.  Either way anything the formatting doesn't
+// touch comes out byte for byte, whatever the line length, and a missing
+// newline at the end stays missing.
+//
+// Failing to write is returned as a *WriteError.  A kernel copy doesn't say
+// which end failed, so it's only for regular files, which were readable
+// enough to open and aren't directories; anything else is read through
+// readSide, which keeps what went wrong reading.
+func (c *CatOptions) Cat(originalInputStream io.Reader, outputStream io.Writer) error {
+	if c.raw() {
+		buf := copyBuffers.Get().([]byte)
+		defer copyBuffers.Put(buf)
+		in, side := originalInputStream, (*readSide)(nil)
+		if regularFile(in) == nil {
+			side = &readSide{r: in}
+			in = side
 		}
-		if c.Number {
-			line, err = c.prependLineNumber(bytes.NewBuffer(line))
-			if err != nil {
-				break
-			}
+		_, err := io.CopyBuffer(outputStream, in, buf)
+		if err != nil && (side == nil || side.err == nil) {
+			err = &WriteError{err}
 		}
+		return err
+	}
 
-		outputStream.Write(line)
-		outputStream.Write([]byte{byte('\n')})
+	if c.pipeline == nil {
+		c.pipeline = c.Pipeline()
 	}
 
-	// Things that act on the final line (even if it is added by cat) as well
-	if c.Number {
-		line, err = c.prependLineNumber(bytes.NewBuffer([]byte("")))
-		outputStream.Write(line)
-		if err != nil {
-			return err
+	var (
+		line, scratch []byte
+		err           error
+	)
+	bufferedReader := bufio.NewReaderSize(originalInputStream, copyBufferSize)
+	bufferedWriter := bufio.NewWriterSize(outputStream, copyBufferSize)
+	for err == nil {
+		line, err = readLine(bufferedReader, line[:0])
+		if len(line) == 0 {
+			break
+		}
+		line, scratch = c.pipeline.Apply(line, scratch)
+		if len(line) == 0 {
+			continue
+		}
+		if _, werr := bufferedWriter.Write(line); werr != nil {
+			return &WriteError{werr}
 		}
 	}
-	if err := bufferedReader.Err(); err != nil {
+	if err != nil && err != io.EOF {
+		if werr := bufferedWriter.Flush(); werr != nil {
+			return &WriteError{werr}
+		}
 		return err
 	}
+	if err := bufferedWriter.Flush(); err != nil {
+		return &WriteError{err}
+	}
 	return nil
 }
@@ -0,0 +1,48 @@
+package cat_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+	"gitlab.com/yarbelk/slimbox/lib/cat"
+	"gitlab.com/yarbelk/slimbox/lib/internal/corpus"
+)
+
+func BenchmarkCat(b *testing.B) {
+	var options = []struct {
+		name    string
+		options cat.CatOptions
+	}{
+		{"plain", cat.CatOptions{}},
+		{"-ET", cat.CatOptions{EoL: true, Tabs: true}},
+		{"-n", cat.CatOptions{Number: true}},
+	}
+	for _, c := range corpus.All() {
+		for _, o := range options {
+			c, o := c, o
+			b.Run(c.Name+"/"+o.name, func(b *testing.B) {
+				b.SetBytes(int64(len(c.Data)))
+				for i := 0; i < b.N; i++ {
+					options := o.options
+					if err := options.Cat(bytes.NewReader(c.Data), io.Discard); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkCatSmallFiles(b *testing.B) {
+	dir, names, size := corpus.SmallFiles(b, 1000)
+	ctx := &lib.Context{Stdout: io.Discard, Stderr: io.Discard, Dir: dir}
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := cat.RunCat(ctx, &cat.CatOptions{Files: names}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,75 +1,162 @@
 package cat
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"syscall"
 
 	"github.com/spf13/pflag"
 	"gitlab.com/yarbelk/slimbox/lib"
+	"gitlab.com/yarbelk/slimbox/lib/decompress"
 )
 
 func init() {
-	lib.RegisterFunction("cat")
+	lib.RegisterApplet(func() lib.Applet { return &applet{options: NewCatOptions()} })
+}
+
+type applet struct {
+	options *CatOptions
+}
+
+func (a *applet) Name() string    { return "cat" }
+func (a *applet) Summary() string { return "concatenate files and print on the standard output" }
+
+func (a *applet) FlagSet() *pflag.FlagSet {
+	return BindFlagSet(a.options)
+}
+
+func (a *applet) Usage() lib.Usage {
+	return lib.Usage{
+		Operands: "[FILE]...",
+		Description: `Concatenate FILE(s) to standard output.
+
+With no FILE, or when FILE is -, read standard input.
+
+With -z, files compressed with gzip, bzip2, zlib, xz or zstd are
+decompressed, and any others copied as they are.`,
+		Epilogue: `Examples:
+  cat f - g  Output f's contents, then standard input, then g's contents.
+  cat        Copy standard input to standard output.`,
+	}
+}
+
+func (a *applet) Run(ctx *lib.Context, args []string) int {
+	a.options.Files = args
+	return lib.ExitCode(RunCat(ctx, a.options))
 }
 
 // BindFlagSet binds the variables in c to the pflag flags
 func BindFlagSet(c *CatOptions) *pflag.FlagSet {
 	fs := pflag.NewFlagSet("cat", pflag.ContinueOnError)
-	fs.BoolVarP(&c.EoL, "show-ends", "E", false, "display $ at endo of each line")
-	fs.BoolVarP(&c.Tabs, "show-tabs", "T", false, "display TAB characters as ^I")
-	fs.BoolVarP(&c.Number, "number", "n", false, "number all output lines")
+	fs.BoolVarP(&c.All, "show-all", "A", false, "equivalent to -vET")
 	fs.BoolVarP(&c.Blank, "number-nonblank", "b", false, "number non-blank output lines, overrides -n")
-	fs.BoolVarP(&c.Help, "help", "h", false, "print this message")
-	setUsage(fs)
+	fs.BoolVarP(&c.NonPrintingEoL, "e", "e", false, "equivalent to -vE")
+	fs.BoolVarP(&c.EoL, "show-ends", "E", false, "display $ at end of each line")
+	fs.BoolVarP(&c.Number, "number", "n", false, "number all output lines")
+	fs.BoolVarP(&c.Squeeze, "squeeze-blank", "s", false, "suppress repeated empty output lines")
+	fs.BoolVarP(&c.NonPrintingTabs, "t", "t", false, "equivalent to -vT")
+	fs.BoolVarP(&c.Tabs, "show-tabs", "T", false, "display TAB characters as ^I")
+	fs.BoolVarP(&c.Unbuffered, "u", "u", false, "(ignored)")
+	fs.BoolVarP(&c.NonPrinting, "show-nonprinting", "v", false, "use ^ and M- notation, except for LFD and TAB")
+	fs.BoolVarP(&c.Decompress, decompress.Flag, decompress.Shorthand, false, "decompress gzip, bzip2, zlib, xz and zstd input")
+	for _, name := range []string{"e", "t", "u"} {
+		lib.ShorthandOnly(fs, name)
+	}
 	return fs
 }
 
-func setUsage(fs *pflag.FlagSet) {
-	fs.Usage = func() {
-		fmt.Fprintf(fs.Output(),
-			"\xff"+`Usage: cat [OPTION]... [FILE]...
-Concatenate FILE(s) to standard output.
-
-With no FILE, or when FILE is -, read standard input.
+// ErrInputIsOutput is cat refusing to read the file it is writing to, which
+// would never end
+var ErrInputIsOutput = errors.New("input file is output file")
 
-`)
-		fs.PrintDefaults()
-		fmt.Fprint(fs.Output(), `
+type stater interface {
+	Stat() (os.FileInfo, error)
+}
 
-Examples:
-  cat f - g  Output f's contents, then standard input, then g's contents.
-  cat        Copy standard input to standard output.
-`)
+// regularFile is w's file info, if it is writing to a regular file
+func regularFile(w interface{}) os.FileInfo {
+	f, ok := w.(stater)
+	if !ok {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	return info
+}
 
+// catFile is one operand of RunCat
+func catFile(ctx *lib.Context, catOptions *CatOptions, file string, output os.FileInfo) error {
+	_, fi, err := ctx.ParseFiles(file)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	// the file itself, as a decoder in front of it can't be stat'd
+	if output != nil {
+		if input := regularFile(fi); input != nil && os.SameFile(input, output) && input.Size() > 0 {
+			return ErrInputIsOutput
+		}
 	}
+	var in io.Reader = fi
+	if catOptions.Decompress {
+		r, err := decompress.NewReader(fi)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		in = r
+	}
+	return catOptions.Cat(in, ctx.Stdout)
 }
 
-// RunCat expects to be running with outputs and files
-func RunCat(catOptions *CatOptions) {
+// RunCat concatenates the files in catOptions to the context's output, the
+// way GNU does.  A file that can't be read is reported on the context's stderr
+// as it happens and cat keeps going with the rest.  Failing to write stops
+// it, quietly if the reader has gone away (EPIPE).  All the failures are
+// returned at the end.
+func RunCat(ctx *lib.Context, catOptions *CatOptions) error {
 	if catOptions.Blank {
 		catOptions.Number = true
 	}
+	if catOptions.All {
+		catOptions.NonPrinting, catOptions.EoL, catOptions.Tabs = true, true, true
+	}
+	if catOptions.NonPrintingEoL {
+		catOptions.NonPrinting, catOptions.EoL = true, true
+	}
+	if catOptions.NonPrintingTabs {
+		catOptions.NonPrinting, catOptions.Tabs = true, true
+	}
 
-	if catOptions.Files == nil || len(catOptions.Files) == 0 {
-		infile := os.NewFile(uintptr(syscall.Stdin), "/dev/stdin")
-		err := catOptions.Cat(infile, os.Stdout)
-		if err != nil {
-			log.Fatal(err)
+	files := catOptions.Files
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	output := regularFile(ctx.Stdout)
+	var errs lib.Errors
+	for _, file := range files {
+		err := catFile(ctx, catOptions, file, output)
+		var writeErr *WriteError
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, syscall.EPIPE):
+			return append(errs, err)
+		case errors.As(err, &writeErr):
+			err = lib.OperandError("cat", "write error", writeErr.Err)
+			fmt.Fprintln(ctx.Stderr, err)
+			return append(errs, err)
 		}
+		err = lib.OperandError("cat", file, err)
+		fmt.Fprintln(ctx.Stderr, err)
+		errs = append(errs, err)
 	}
-	for _, file := range catOptions.Files {
-		func() {
-			_, fi, err := lib.ParseFiles(file)
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer fi.Close()
-			err = catOptions.Cat(fi, os.Stdout)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}()
+	if len(errs) > 0 {
+		return errs
 	}
+	return nil
 }
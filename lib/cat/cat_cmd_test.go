@@ -0,0 +1,248 @@
+package cat_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+	_ "gitlab.com/yarbelk/slimbox/lib/cat"
+)
+
+// Many cats at once, each with its own streams, shouldn't see each other
+func TestRunInProcess(t *testing.T) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			ctx := &lib.Context{Stdin: strings.NewReader(fmt.Sprintf("cat %d\n", i)), Stdout: stdout, Stderr: stderr}
+			if status := lib.RunContext(ctx, "cat", []string{"-E"}); status != 0 {
+				t.Errorf("%d: expected exit 0, actual %d: %s", i, status, stderr)
+			}
+			if expected := fmt.Sprintf("cat %d$\n", i); stdout.String() != expected {
+				t.Errorf("expected %q, actual %q", expected, stdout)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestKeepsGoingPastMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx := &lib.Context{Stdout: stdout, Stderr: stderr, Dir: dir}
+
+	status := lib.RunContext(ctx, "cat", []string{"a", "missing", "a"})
+	if status != lib.ExitFailure {
+		t.Errorf("expected exit %d, actual %d", lib.ExitFailure, status)
+	}
+	if stdout.String() != "a\na\n" {
+		t.Errorf("expected both copies of a, actual %q", stdout)
+	}
+	if expected := "cat: missing: No such file or directory\n"; stderr.String() != expected {
+		t.Errorf("expected %q, actual %q", expected, stderr)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	dir := t.TempDir()
+	compressed := &bytes.Buffer{}
+	zw := gzip.NewWriter(compressed)
+	zw.Write([]byte("one\ntwo\n"))
+	zw.Close()
+	if err := os.WriteFile(filepath.Join(dir, "a.gz"), compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx := &lib.Context{Stdin: strings.NewReader("plain\n"), Stdout: stdout, Stderr: stderr, Dir: dir}
+
+	if status := lib.RunContext(ctx, "cat", []string{"-zn", "a.gz", "-"}); status != 0 {
+		t.Fatalf("expected exit 0, actual %d: %s", status, stderr)
+	}
+	if expected := "     1\tone\n     2\ttwo\n     3\tplain\n"; stdout.String() != expected {
+		t.Errorf("expected %q, actual %q", expected, stdout)
+	}
+}
+
+// Without formatting, file to file goes through the kernel and comes out byte for byte
+func TestRawCopyBetweenFiles(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 1<<20+7)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "in"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &lib.Context{Stdout: out, Stderr: &bytes.Buffer{}, Dir: dir}
+	status := lib.RunContext(ctx, "cat", []string{"in", "in"})
+	out.Close()
+	if status != lib.ExitSuccess {
+		t.Fatalf("expected exit 0, actual %d: %s", status, ctx.Stderr)
+	}
+	actual, err := os.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, append(data, data...)) {
+		t.Errorf("output differs from two copies of the input")
+	}
+}
+
+func TestRefusesInputIsOutput(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "f")
+	if err := os.WriteFile(name, []byte("abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stderr := &bytes.Buffer{}
+	ctx := &lib.Context{Stdout: out, Stderr: stderr, Dir: dir}
+
+	if status := lib.RunContext(ctx, "cat", []string{"f", "-n", "f"}); status != lib.ExitFailure {
+		t.Errorf("expected exit %d, actual %d", lib.ExitFailure, status)
+	}
+	expected := "cat: f: input file is output file\ncat: f: input file is output file\n"
+	if stderr.String() != expected {
+		t.Errorf("expected %q, actual %q", expected, stderr)
+	}
+	if actual, _ := os.ReadFile(name); string(actual) != "abc\n" {
+		t.Errorf("expected the file untouched, actual %q", actual)
+	}
+}
+
+func TestQuietOnBrokenPipe(t *testing.T) {
+	for _, args := range [][]string{{"-"}, {"-n", "-"}} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+		stderr := &bytes.Buffer{}
+		ctx := &lib.Context{Stdin: strings.NewReader(strings.Repeat("y\n", 1<<16)), Stdout: w, Stderr: stderr}
+
+		if status := lib.RunContext(ctx, "cat", append(args, "-")); status != lib.ExitFailure {
+			t.Errorf("%v: expected exit %d, actual %d", args, lib.ExitFailure, status)
+		}
+		if stderr.Len() != 0 {
+			t.Errorf("%v: expected nothing on stderr, actual %q", args, stderr)
+		}
+		w.Close()
+	}
+}
+
+// A directory fails reading, not writing, even when the copy would go through
+// the kernel to a real file or pipe, so cat carries on with the next operand
+func TestDirectoryIsAReadError(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("f\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for name, stdout := range map[string]*os.File{"file": out, "pipe": w} {
+		stderr := &bytes.Buffer{}
+		ctx := &lib.Context{Stdout: stdout, Stderr: stderr, Dir: dir}
+		if status := lib.RunContext(ctx, "cat", []string{"d", "f"}); status != lib.ExitFailure {
+			t.Errorf("%s: expected exit %d, actual %d", name, lib.ExitFailure, status)
+		}
+		if expected := "cat: d: Is a directory\n"; stderr.String() != expected {
+			t.Errorf("%s: expected %q, actual %q", name, expected, stderr)
+		}
+	}
+	w.Close()
+	if piped, _ := io.ReadAll(r); string(piped) != "f\n" {
+		t.Errorf("pipe: expected f, actual %q", piped)
+	}
+	if written, _ := os.ReadFile(filepath.Join(dir, "out")); string(written) != "f\n" {
+		t.Errorf("file: expected f, actual %q", written)
+	}
+}
+
+// -z puts a decoder in front of a compressed file, which mustn't hide that
+// it's the output
+func TestDecompressRefusesInputIsOutput(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "f")
+	compressed := &bytes.Buffer{}
+	zw := gzip.NewWriter(compressed)
+	zw.Write([]byte("abc\n"))
+	zw.Close()
+	if err := os.WriteFile(name, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stderr := &bytes.Buffer{}
+	ctx := &lib.Context{Stdout: out, Stderr: stderr, Dir: dir}
+
+	if status := lib.RunContext(ctx, "cat", []string{"-z", "f"}); status != lib.ExitFailure {
+		t.Errorf("expected exit %d, actual %d", lib.ExitFailure, status)
+	}
+	if expected := "cat: f: input file is output file\n"; stderr.String() != expected {
+		t.Errorf("expected %q, actual %q", expected, stderr)
+	}
+	if actual, _ := os.ReadFile(name); !bytes.Equal(actual, compressed.Bytes()) {
+		t.Errorf("expected the file untouched, actual %q", actual)
+	}
+}
+
+// -e, -t and -u are shorthands only, like GNU's
+func TestShorthandOnlyFlags(t *testing.T) {
+	for _, flag := range []string{"--e", "--t", "--u=true"} {
+		stderr := &bytes.Buffer{}
+		ctx := &lib.Context{Stdin: strings.NewReader(""), Stdout: &bytes.Buffer{}, Stderr: stderr}
+		if status := lib.RunContext(ctx, "cat", []string{flag}); status != lib.ExitUsage {
+			t.Errorf("%s: expected exit %d, actual %d", flag, lib.ExitUsage, status)
+		}
+		if name := strings.SplitN(flag, "=", 2)[0]; !strings.HasPrefix(stderr.String(), "cat: unknown flag: "+name+"\n") {
+			t.Errorf("%s: expected unknown flag, actual %q", flag, stderr)
+		}
+	}
+	help := &bytes.Buffer{}
+	if err := lib.Help(help, "cat"); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(help.String(), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "-e") && line != "  -e                       equivalent to -vE" {
+			t.Errorf("expected -e with no long option, actual %q", line)
+		}
+	}
+	if strings.Contains(help.String(), "--e ") || strings.Contains(help.String(), "--t ") || strings.Contains(help.String(), "--u ") {
+		t.Errorf("expected no --e, --t or --u in:\n%s", help)
+	}
+}
@@ -50,7 +50,7 @@ func TestAddLineNumbers(t *testing.T) {
 	var inputValue string = "Hello\nWorld!\n"
 	var inputReader = strings.NewReader(inputValue)
 	var outputStream bytes.Buffer
-	var expectedValue string = "     1  Hello\n     2  World!\n     3  "
+	var expectedValue string = "     1\tHello\n     2\tWorld!\n"
 
 	c = NewCatOptions()
 	c.Number = true
@@ -68,7 +68,7 @@ func TestAddLineNumbersToNonBlank(t *testing.T) {
 	var inputValue string = "Hello\n\nWorld!\n"
 	var inputReader = strings.NewReader(inputValue)
 	var outputStream bytes.Buffer
-	var expectedValue string = "     1  Hello\n\n     2  World!\n"
+	var expectedValue string = "     1\tHello\n\n     2\tWorld!\n"
 
 	c = NewCatOptions()
 	c.Blank = true
@@ -81,3 +81,47 @@ func TestAddLineNumbersToNonBlank(t *testing.T) {
 		t.Fatalf("Expected %q, was %q", expectedValue, recievedValue)
 	}
 }
+
+// Lines longer than bufio.Scanner's 64KiB limit, and a last line with no newline
+func TestLongLinesAndNoTrailingNewline(t *testing.T) {
+	long := strings.Repeat("x", 100<<10)
+	var inputValue string = long + "\t\n" + "no newline"
+	var outputStream bytes.Buffer
+	var expectedValue string = long + "^I$\n" + "no newline"
+
+	c = &CatOptions{EoL: true, Tabs: true}
+	if err := c.Cat(strings.NewReader(inputValue), &outputStream); err != nil {
+		t.Fatal(err)
+	}
+
+	if recievedValue := outputStream.String(); expectedValue != recievedValue {
+		t.Fatalf("Expected %d bytes ending %q, was %d bytes ending %q", len(expectedValue), expectedValue[len(expectedValue)-20:], len(recievedValue), recievedValue[len(recievedValue)-20:])
+	}
+}
+
+func TestShowNonPrintingAndSqueeze(t *testing.T) {
+	var tests = []struct {
+		name     string
+		options  CatOptions
+		given    string
+		expected string
+	}{
+		{"control characters", CatOptions{NonPrinting: true}, "a\x00\x1b\x7f\tb\n", "a^@^[^?\tb\n"},
+		{"high bytes", CatOptions{NonPrinting: true}, "\x80\x89\xa0\xe4\xff\n", "M-^@M-^IM- M-dM-^?\n"},
+		{"carriage return", CatOptions{NonPrinting: true, EoL: true}, "a\r\n", "a^M$\n"},
+		{"squeeze", CatOptions{Squeeze: true}, "a\n\n\n\nb\n\n", "a\n\nb\n\n"},
+		{"squeeze then mark ends", CatOptions{Squeeze: true, EoL: true}, "\n\n\na\n", "$\na$\n"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var outputStream bytes.Buffer
+			if err := tt.options.Cat(strings.NewReader(tt.given), &outputStream); err != nil {
+				t.Fatal(err)
+			}
+			if recievedValue := outputStream.String(); tt.expected != recievedValue {
+				t.Fatalf("Expected %q, was %q", tt.expected, recievedValue)
+			}
+		})
+	}
+}
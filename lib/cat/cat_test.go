@@ -5,7 +5,7 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/yarbelk/slimbox/lib/cat"
+	"gitlab.com/yarbelk/slimbox/lib/cat"
 )
 
 func TestOneFileInputs(t *testing.T) {
@@ -16,9 +16,9 @@ func TestOneFileInputs(t *testing.T) {
 	}{
 		{"Empty File", "", strings.NewReader("")},
 		{"One Line File with newline", "Hello world!\n", strings.NewReader("Hello world!\n")},
-		{"One Line File with no trailing newline", "Hello world!\n", strings.NewReader("Hello world!")},
+		{"One Line File with no trailing newline", "Hello world!", strings.NewReader("Hello world!")},
 		{"Multiple lines with trailing newline", "Hello world!\n\nHello Gophers!\n", strings.NewReader("Hello world!\n\nHello Gophers!\n")},
-		{"Multiple lines with no trailing newline", "Hello world!\n\nHello Gophers!\n", strings.NewReader("Hello world!\n\nHello Gophers!")},
+		{"Multiple lines with no trailing newline", "Hello world!\n\nHello Gophers!", strings.NewReader("Hello world!\n\nHello Gophers!")},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -44,8 +44,8 @@ func TestMultipleInputs(t *testing.T) {
 	}{
 		{"Two Empty Files", "", []*strings.Reader{strings.NewReader(""), strings.NewReader("")}},
 		{"One Line Files with newline", "Hello world!\nHello Gophers!\n", []*strings.Reader{strings.NewReader("Hello world!\n"), strings.NewReader("Hello Gophers!\n")}},
-		{"One Line Files with no trailing newline", "Hello world!\nHello Gophers!\n", []*strings.Reader{strings.NewReader("Hello world!"), strings.NewReader("Hello Gophers!")}},
-		{"Mixed trailing newlines", "Hello world!\nHello Gophers!\n", []*strings.Reader{strings.NewReader("Hello world!\n"), strings.NewReader("Hello Gophers!")}},
+		{"One Line Files with no trailing newline", "Hello world!Hello Gophers!", []*strings.Reader{strings.NewReader("Hello world!"), strings.NewReader("Hello Gophers!")}},
+		{"Mixed trailing newlines", "Hello world!\nHello Gophers!", []*strings.Reader{strings.NewReader("Hello world!\n"), strings.NewReader("Hello Gophers!")}},
 	}
 	for _, tt := range tests {
 		tt := tt
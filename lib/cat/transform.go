@@ -0,0 +1,151 @@
+package cat
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Transform is one stage of the line pipeline cat's formatting options are
+// built from.  Other applets, or code embedding this one, can reuse the
+// stages or add their own.
+type Transform interface {
+	// Transform appends its version of line to dst, and returns it.  line is
+	// everything up to and including a '\n', or the bytes after the last one
+	// at the end of the input; it is never empty.  Appending nothing drops
+	// the line, and the stages after don't see it.
+	Transform(dst, line []byte) []byte
+}
+
+// TransformFunc lets a plain function be a Transform
+type TransformFunc func(dst, line []byte) []byte
+
+func (f TransformFunc) Transform(dst, line []byte) []byte {
+	return f(dst, line)
+}
+
+// Pipeline is Transforms applied to each line in order
+type Pipeline []Transform
+
+// Apply runs line through each stage of p.  Stages write into the spare
+// buffer and swap it with the line, so once the two have grown to fit the
+// longest line nothing more is allocated.  It returns the result, empty if a
+// stage dropped the line, and the buffer that is now spare.
+func (p Pipeline) Apply(line, scratch []byte) (result, spare []byte) {
+	for _, stage := range p {
+		scratch = stage.Transform(scratch[:0], line)
+		line, scratch = scratch, line
+		if len(line) == 0 {
+			break
+		}
+	}
+	return line, scratch
+}
+
+// ShowEnds marks the end of a line with '$', and a '\r' just before it as ^M.
+// A last line with no '\n' has no end to mark.
+var ShowEnds = TransformFunc(func(dst, line []byte) []byte {
+	if line[len(line)-1] != '\n' {
+		return append(dst, line...)
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		dst = append(dst, line[:len(line)-1]...)
+		dst = append(dst, '^', 'M')
+	} else {
+		dst = append(dst, line...)
+	}
+	return append(dst, '$', '\n')
+})
+
+// ShowTabs displays TAB characters as ^I
+var ShowTabs = TransformFunc(func(dst, line []byte) []byte {
+	for {
+		i := bytes.IndexByte(line, '\t')
+		if i < 0 {
+			return append(dst, line...)
+		}
+		dst = append(dst, line[:i]...)
+		dst = append(dst, '^', 'I')
+		line = line[i+1:]
+	}
+})
+
+// ShowNonPrinting uses ^ and M- notation for control characters and bytes
+// with the high bit set, leaving the '\n' and TAB alone like GNU does
+var ShowNonPrinting = TransformFunc(func(dst, line []byte) []byte {
+	for _, b := range line {
+		if b >= 128 {
+			dst = append(dst, 'M', '-')
+			b -= 128
+		} else if b == '\t' || b == '\n' {
+			dst = append(dst, b)
+			continue
+		}
+		switch {
+		case b < 32:
+			dst = append(dst, '^', b+64)
+		case b == 127:
+			dst = append(dst, '^', '?')
+		default:
+			dst = append(dst, b)
+		}
+	}
+	return dst
+})
+
+// SqueezeBlank drops empty lines that follow an empty line.  It remembers the
+// last line it saw, so the same one squeezes across files.
+type SqueezeBlank struct {
+	lastBlank bool
+	midLine   bool
+}
+
+func (s *SqueezeBlank) Transform(dst, line []byte) []byte {
+	// a '\n' that ends the last file's unfinished line isn't an empty line
+	blank := line[0] == '\n' && !s.midLine
+	s.midLine = line[len(line)-1] != '\n'
+	if blank && s.lastBlank {
+		return dst
+	}
+	s.lastBlank = blank
+	return append(dst, line...)
+}
+
+// LineNumber puts the line number, right aligned in six columns, and a tab
+// in front of each line, like GNU.  It counts on from the last line it saw,
+// so the same one numbers across files; and a line that carries on from a
+// file that didn't end in a newline isn't numbered again.
+type LineNumber struct {
+	// Next is the number the next line gets
+	Next int
+	// NonBlank only numbers lines with something on them
+	NonBlank bool
+
+	midLine bool
+}
+
+// NewLineNumber numbers lines from 1
+func NewLineNumber(nonBlank bool) *LineNumber {
+	return &LineNumber{Next: 1, NonBlank: nonBlank}
+}
+
+func (n *LineNumber) Transform(dst, line []byte) []byte {
+	atStart := !n.midLine
+	n.midLine = line[len(line)-1] != '\n'
+	if atStart && (!n.NonBlank || line[0] != '\n') {
+		dst = n.appendNumber(dst)
+	}
+	return append(dst, line...)
+}
+
+func (n *LineNumber) appendNumber(dst []byte) []byte {
+	start := len(dst)
+	dst = strconv.AppendInt(dst, int64(n.Next), 10)
+	n.Next++
+	if width := len(dst) - start; width < 6 {
+		dst = append(dst, "      "[:6-width]...)
+		copy(dst[start+6-width:], dst[start:start+width])
+		copy(dst[start:], "      "[:6-width])
+	}
+	return append(dst, '\t')
+}
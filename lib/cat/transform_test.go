@@ -0,0 +1,64 @@
+package cat_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib/cat"
+)
+
+// redact is the sort of stage someone embedding cat might add
+var redact = cat.TransformFunc(func(dst, line []byte) []byte {
+	if bytes.HasPrefix(line, []byte("password")) {
+		return append(dst, "password=REDACTED\n"...)
+	}
+	return append(dst, line...)
+})
+
+func TestCustomTransforms(t *testing.T) {
+	given := "user=gopher\npassword=hunter2\n\tdone\n"
+	expected := "     1\tuser=gopher$\n     2\tpassword=REDACTED$\n     3\t^Idone$\n"
+
+	outputStream := &bytes.Buffer{}
+	C := &cat.CatOptions{Transforms: []cat.Transform{redact}, EoL: true, Tabs: true, Number: true}
+	if err := C.Cat(strings.NewReader(given), outputStream); err != nil {
+		t.Fatal(err)
+	}
+	if actual := outputStream.String(); actual != expected {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+}
+
+func TestPipelineReusesBuffers(t *testing.T) {
+	pipeline := cat.Pipeline{&cat.SqueezeBlank{}, cat.ShowNonPrinting, cat.ShowTabs, cat.NewLineNumber(false), cat.ShowEnds}
+	line, scratch := make([]byte, 0, 256), make([]byte, 0, 256)
+	allocs := testing.AllocsPerRun(100, func() {
+		line = append(line[:0], "a\tb\x01\r\n"...)
+		line, scratch = pipeline.Apply(line, scratch)
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations per line, actual %v", allocs)
+	}
+	if expected := "   101\ta^Ib^A^M$\n"; string(line) != expected {
+		t.Errorf("expected %q, actual %q", expected, line)
+	}
+}
+
+func TestLineNumberWidths(t *testing.T) {
+	var tests = []struct {
+		next     int
+		expected string
+	}{
+		{1, "     1\tx\n"},
+		{99999, " 99999\tx\n"},
+		{123456, "123456\tx\n"},
+		{1234567, "1234567\tx\n"},
+	}
+	for _, tt := range tests {
+		numbers := &cat.LineNumber{Next: tt.next}
+		if actual := string(numbers.Transform(nil, []byte("x\n"))); actual != tt.expected {
+			t.Errorf("expected %q, actual %q", tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package conformance_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+	_ "gitlab.com/yarbelk/slimbox/lib/cat"
+	_ "gitlab.com/yarbelk/slimbox/lib/falsy"
+	_ "gitlab.com/yarbelk/slimbox/lib/sh"
+	_ "gitlab.com/yarbelk/slimbox/lib/truthy"
+	_ "gitlab.com/yarbelk/slimbox/lib/wc"
+)
+
+var update = flag.Bool("update", false, "record the golden files from the GNU tools on your PATH")
+
+var (
+	fixtures = filepath.Join("testdata", "fixtures")
+	golden   = filepath.Join("testdata", "golden")
+	// env for both GNU and slimbox, so -m and friends agree on the charset
+	env = []string{"LC_ALL=C.UTF-8"}
+)
+
+type testCase struct {
+	name   string
+	applet string
+	args   []string
+	// stdin is the fixture fed to standard input, if any
+	stdin string
+	// env is added to the environment, after env
+	env []string
+	// known is why slimbox doesn't match GNU yet.  The case is skipped while
+	// it differs, and fails once it matches so the note gets dropped.
+	known string
+}
+
+var tests = []testCase{
+	{name: "true", applet: "true"},
+	{name: "true-ignores-args", applet: "true", args: []string{"--bogus", "x"}},
+	{name: "false", applet: "false"},
+
+	{name: "cat", applet: "cat", args: []string{"ascii.txt"}},
+	{name: "cat-stdin", applet: "cat", stdin: "ascii.txt"},
+	{name: "cat-files-and-stdin", applet: "cat", args: []string{"ascii.txt", "-", "tabs.txt"}, stdin: "blank.txt"},
+	{name: "cat-no-eol", applet: "cat", args: []string{"no-eol.txt"}},
+	{name: "cat-no-eol-then-file", applet: "cat", args: []string{"no-eol.txt", "ascii.txt"}},
+	{name: "cat-E", applet: "cat", args: []string{"-E", "ascii.txt", "tabs.txt"}},
+	{name: "cat-E-crlf", applet: "cat", args: []string{"-E", "crlf.txt"}},
+	{name: "cat-ET-long-line", applet: "cat", args: []string{"-ET", "long.txt"}},
+	{name: "cat-binary", applet: "cat", args: []string{"binary.bin"}},
+	{name: "cat-E-binary", applet: "cat", args: []string{"-E", "binary.bin"}},
+	{name: "cat-T", applet: "cat", args: []string{"-T", "tabs.txt"}},
+	{name: "cat-n", applet: "cat", args: []string{"-n", "ascii.txt"}},
+	{name: "cat-n-files", applet: "cat", args: []string{"-n", "ascii.txt", "blank.txt"}},
+	{name: "cat-b", applet: "cat", args: []string{"-b", "blank.txt"}},
+	{name: "cat-n-no-eol", applet: "cat", args: []string{"-n", "no-eol.txt", "no-eol.txt", "-", "ascii.txt"}, stdin: "no-eol.txt"},
+	{name: "cat-b-no-eol", applet: "cat", args: []string{"-b", "no-eol.txt", "blank.txt"}},
+	{name: "cat-bE", applet: "cat", args: []string{"-bE", "blank.txt", "crlf.txt"}},
+	{name: "cat-nT", applet: "cat", args: []string{"-nT", "tabs.txt"}},
+	{name: "cat-ns-no-eol", applet: "cat", args: []string{"-ns", "no-eol.txt", "blank.txt"}},
+	{name: "cat-A", applet: "cat", args: []string{"-A", "crlf.txt", "tabs.txt"}},
+	{name: "cat-v-binary", applet: "cat", args: []string{"-v", "binary.bin"}},
+	{name: "cat-e", applet: "cat", args: []string{"-e", "crlf.txt"}},
+	{name: "cat-t", applet: "cat", args: []string{"-t", "tabs.txt", "binary.bin"}},
+	{name: "cat-s", applet: "cat", args: []string{"-s", "blank.txt", "blank.txt"}},
+	{name: "cat-sb", applet: "cat", args: []string{"-sb", "blank.txt"}},
+	{name: "cat-u", applet: "cat", args: []string{"-u", "ascii.txt"}},
+	{name: "cat-utf8", applet: "cat", args: []string{"utf8.txt"}},
+	{name: "cat-missing", applet: "cat", args: []string{"ascii.txt", "missing", "tabs.txt"}},
+	{name: "cat-directory", applet: "cat", args: []string{"dir", "ascii.txt"}},
+	{name: "cat-bad-flag", applet: "cat", args: []string{"--bogus"},
+		known: "usage errors exit 2 and pflag words them differently"},
+
+	{name: "wc", applet: "wc", args: []string{"ascii.txt"}},
+	{name: "wc-stdin", applet: "wc", stdin: "ascii.txt"},
+	{name: "wc-l", applet: "wc", args: []string{"-l", "ascii.txt"}},
+	{name: "wc-w", applet: "wc", args: []string{"-w", "ascii.txt"}},
+	{name: "wc-c", applet: "wc", args: []string{"-c", "ascii.txt"}},
+	{name: "wc-m", applet: "wc", args: []string{"-m", "utf8.txt"}},
+	{name: "wc-L", applet: "wc", args: []string{"-L", "tabs.txt"}},
+	{name: "wc-utf8", applet: "wc", args: []string{"-lwmcL", "utf8.txt"}},
+	{name: "wc-files", applet: "wc", args: []string{"ascii.txt", "tabs.txt", "no-eol.txt"}},
+	{name: "wc-directory", applet: "wc", args: []string{"dir", "ascii.txt"}},
+	{name: "wc-dash", applet: "wc", args: []string{"-l", "-"}, stdin: "ascii.txt"},
+	{name: "wc-missing", applet: "wc", args: []string{"ascii.txt", "missing"}},
+	{name: "wc-words", applet: "wc", args: []string{"-lwmc", "words.txt"}},
+	{name: "wc-words-c-locale", applet: "wc", args: []string{"-lwmc", "words.txt"}, env: []string{"LC_ALL=C"}},
+	{name: "wc-words-L", applet: "wc", args: []string{"-L", "words.txt"}},
+	{name: "wc-widths-L", applet: "wc", args: []string{"-L", "widths.txt"}},
+	{name: "wc-widths-L-c-locale", applet: "wc", args: []string{"-L", "widths.txt"}, env: []string{"LC_ALL=C"}},
+	{name: "wc-emoji-L", applet: "wc", args: []string{"-L", "emoji.txt"},
+		known: "-L measures an emoji sequence as the one emoji a terminal shows; GNU adds up the characters in it"},
+	{name: "wc-files0-from", applet: "wc", args: []string{"--files0-from=list0"}},
+	{name: "wc-files0-from-stdin", applet: "wc", args: []string{"-l", "--files0-from=-"}, stdin: "list0"},
+	{name: "wc-files0-from-bad", applet: "wc", args: []string{"--files0-from=-"}, stdin: "list0-bad"},
+	{name: "wc-files0-from-dash", applet: "wc", args: []string{"--files0-from", "list0-stdin"}, stdin: "ascii.txt"},
+	{name: "wc-files0-from-operand", applet: "wc", args: []string{"--files0-from=list0", "ascii.txt"}},
+	{name: "wc-files0-from-missing", applet: "wc", args: []string{"--files0-from=missing"}},
+	{name: "wc-files0-from-dir", applet: "wc", args: []string{"--files0-from=dir"}},
+
+	{name: "sh-script", applet: "sh", args: []string{"script.sh"}},
+	{name: "sh-stdin", applet: "sh", args: []string{"-s", "a", "b"}, stdin: "script-stdin.sh"},
+	{name: "sh-redirect", applet: "sh", args: []string{"redirect.sh"}},
+	{name: "sh-builtins", applet: "sh", args: []string{"builtins.sh"}},
+	{name: "sh-c", applet: "sh", args: []string{"-c", `echo "$0" "$@"; echo $#`, "name", "a", "b c"}},
+	{name: "sh-c-syntax-error", applet: "sh", args: []string{"-c", "echo ok; if true; then"}},
+	{name: "sh-c-not-found", applet: "sh", args: []string{"-c", "missing-command; echo $?"}},
+	{name: "sh-c-errexit", applet: "sh", args: []string{"-e", "-c", "echo a; false; echo b"}},
+	{name: "sh-c-exit", applet: "sh", args: []string{"-c", "(exit 4); echo $?; exit 3"}},
+	{name: "sh-c-redirect-error", applet: "sh", args: []string{"-c", "echo a > dir; echo $?; cat <&7; : > missing/x; echo unreachable"}},
+	{name: "sh-missing-script", applet: "sh", args: []string{"missing"}},
+}
+
+// result is what an applet did
+type result struct {
+	stdout, stderr []byte
+	status         int
+}
+
+func TestConformance(t *testing.T) {
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if *update {
+				record(t, tt)
+			}
+			expected := load(t, tt.name)
+			actual := run(t, tt)
+			diffs := compare(expected, actual)
+
+			switch {
+			case len(diffs) == 0 && tt.known != "":
+				t.Errorf("matches GNU now; drop the known divergence %q", tt.known)
+			case len(diffs) != 0 && tt.known != "":
+				t.Skipf("known divergence: %s", tt.known)
+			case len(diffs) != 0:
+				t.Errorf("%s %s:\n%s", tt.applet, strings.Join(tt.args, " "), strings.Join(diffs, "\n"))
+			}
+		})
+	}
+}
+
+func compare(expected, actual result) []string {
+	var diffs []string
+	if !bytes.Equal(expected.stdout, actual.stdout) {
+		diffs = append(diffs, "stdout:\n\texpected "+strconv.Quote(string(expected.stdout))+"\n\tactual   "+strconv.Quote(string(actual.stdout)))
+	}
+	if !bytes.Equal(expected.stderr, actual.stderr) {
+		diffs = append(diffs, "stderr:\n\texpected "+strconv.Quote(string(expected.stderr))+"\n\tactual   "+strconv.Quote(string(actual.stderr)))
+	}
+	if expected.status != actual.status {
+		diffs = append(diffs, "status: expected "+strconv.Itoa(expected.status)+", actual "+strconv.Itoa(actual.status))
+	}
+	return diffs
+}
+
+func stdin(t *testing.T, tt testCase) *os.File {
+	if tt.stdin == "" {
+		return nil
+	}
+	in, err := os.Open(filepath.Join(fixtures, tt.stdin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { in.Close() })
+	return in
+}
+
+// run the slimbox applet in-process
+func run(t *testing.T, tt testCase) result {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx := &lib.Context{Stdin: strings.NewReader(""), Stdout: stdout, Stderr: stderr, Dir: fixtures, Env: append(env, tt.env...)}
+	if in := stdin(t, tt); in != nil {
+		ctx.Stdin = in
+	}
+	status := lib.RunContext(ctx, tt.applet, tt.args)
+	return result{stdout: stdout.Bytes(), stderr: stderr.Bytes(), status: status}
+}
+
+// record the GNU applet's behaviour as the golden files for tt
+func record(t *testing.T, tt testCase) {
+	path, err := exec.LookPath(tt.applet)
+	if err != nil {
+		t.Fatalf("can't record %s: %s", tt.name, err)
+	}
+	cmd := exec.Command(path, tt.args...)
+	cmd.Args[0] = tt.applet
+	cmd.Dir = fixtures
+	cmd.Env = append(env, tt.env...)
+	if in := stdin(t, tt); in != nil {
+		cmd.Stdin = in
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	status := 0
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("can't record %s: %s", tt.name, err)
+		}
+		status = exitErr.ExitCode()
+	}
+
+	base := filepath.Join(golden, tt.name)
+	for ext, content := range map[string][]byte{
+		".stdout": stdout.Bytes(),
+		".stderr": stderr.Bytes(),
+		".status": []byte(strconv.Itoa(status) + "\n"),
+	} {
+		if err := os.WriteFile(base+ext, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func load(t *testing.T, name string) result {
+	base := filepath.Join(golden, name)
+	var r result
+	var status []byte
+	for ext, content := range map[string]*[]byte{".stdout": &r.stdout, ".stderr": &r.stderr, ".status": &status} {
+		b, err := os.ReadFile(base + ext)
+		if err != nil {
+			t.Fatalf("%s; record it with -update", err)
+		}
+		*content = b
+	}
+	s, err := strconv.Atoi(strings.TrimSpace(string(status)))
+	if err != nil {
+		t.Fatalf("%s.status: %s", base, err)
+	}
+	r.status = s
+	return r
+}
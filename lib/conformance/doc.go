@@ -0,0 +1,10 @@
+// Package conformance checks slimbox's applets against the GNU coreutils they
+// copy.  Each case runs an applet in-process over the files in
+// testdata/fixtures and compares its stdout, stderr and exit status with the
+// golden files in testdata/golden, which were recorded from GNU.  sh is
+// checked against dash, the /bin/sh it copies.
+//
+// To re-record the golden files from the GNU tools on your PATH:
+//
+//	go test ./lib/conformance -update
+package conformance
@@ -0,0 +1,58 @@
+# trap, getopts, command, umask and kill, without touching anything outside
+# the script: the mask is put back and the only signal is to the shell itself
+trap 'echo "exiting with $?"' EXIT
+trap 'echo "got USR1"' USR1
+trap
+kill -USR1 $$
+echo "after the signal"
+trap - USR1
+(trap 'echo "subshell done"' EXIT; echo "in a subshell")
+x=$(trap 'echo trapped' EXIT; echo value)
+echo "substituted [$x]"
+
+parse() {
+	OPTIND=1
+	while getopts :ab:c opt; do
+		case $opt in
+		b) echo "b is $OPTARG" ;;
+		:) echo "-$OPTARG needs an argument" ;;
+		\?) echo "no -$OPTARG" ;;
+		*) echo "-$opt" ;;
+		esac
+	done
+	shift $((OPTIND - 1))
+	echo "operands: $*"
+}
+parse -a -b one -cbtwo -z -- rest
+parse -ac operand
+parse -b
+OPTIND=1
+getopts a opt -x
+echo "status $? opt $opt"
+
+echo() { command echo "function: $*"; }
+echo "from the function"
+command echo "from the builtin"
+unset -f echo
+command -v cd
+command -V set
+command -V if
+command -V no-such-command || echo "not found: $?"
+command set -o no-such-option
+echo "command kept the shell going: $?"
+
+old=$(umask)
+umask 027
+umask
+umask -S
+umask g+w,o=r
+umask
+umask u=rwx,g=rx,o=
+umask -S
+umask "$old"
+
+kill -l 9
+kill -l 143
+kill -s NOPE $$ || echo "kill failed: $?"
+kill -0 $$ && echo "the shell is there"
+exit 3
@@ -0,0 +1,47 @@
+# the here-documents and descriptor juggling init scripts do, writing no
+# files but /dev/null
+name=world
+cat <<EOF
+hello, $name: $((6 * 7)) `echo back` \$name "quoted" 'single'
+continued \
+line
+EOF
+cat <<'EOF'
+literal $name `echo not run`
+EOF
+cat <<-EOF
+	tabs stripped for $name
+	EOF
+
+while read key value; do
+	echo "$key is $value"
+done <<EOF
+one 1
+two 2
+EOF
+
+count() { wc -l; }
+count <<EOF
+a
+b
+c
+EOF
+
+exec 3>&1
+echo "to fd 3" >&3
+{ echo "stdout"; echo "stderr" >&2; } 2>&1 | wc -l
+{ echo "kept"; echo "dropped" >&2; } 2>/dev/null
+echo "both gone" >/dev/null 2>&1
+exec 3>&-
+echo "closed" 2>/dev/null >&3 || echo "fd 3 is closed"
+
+exec 4<ascii.txt
+wc -l <&4
+exec 4<&-
+
+cat < missing || echo "cat failed with $?"
+echo "$(cat <<EOF
+in a substitution
+EOF
+)"
+echo done
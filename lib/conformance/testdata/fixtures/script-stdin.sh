@@ -0,0 +1,5 @@
+echo "from stdin: $#"
+x=1 y=$x
+echo "[$y]"
+echo ${missing?is not set}
+echo never
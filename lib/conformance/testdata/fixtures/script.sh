@@ -0,0 +1,52 @@
+# exercises the shell's grammar and expansions, with no commands from $PATH
+# but the applets
+greet() {
+	echo "hello, $1"
+	return 3
+}
+greet world
+echo "greet returned $?"
+
+for f in *.txt; do
+	case $f in
+	a*.txt) echo "a file: $f" ;;
+	[tu]*) echo "t or u file: $f" ;;
+	*) ;;
+	esac
+done
+
+i=0
+while [ $i -lt 3 ]; do
+	i=$((i + 1))
+	if [ $i -eq 2 ]; then
+		continue
+	fi
+	echo "i=$i"
+done
+until [ $i -eq 0 ]; do i=$((i - 1)); done
+echo "i is back to $i"
+
+set -- one "two three" four
+echo "$# params: $*"
+for p in "$@"; do echo "[$p]"; done
+shift
+echo "after shift: $1"
+
+path=/usr/local/lib/file.tar.gz
+echo "${path##*/} ${path%.*} ${path%%.*} ${path#/usr}"
+echo "${unset:-default} ${unset-dash} [${path:+set}] ${#path}"
+echo "$(echo nested "$(echo deeper)")" `echo backquoted`
+
+IFS=:
+list=a:b::c
+for x in $list; do echo "<$x>"; done
+unset IFS
+
+( cd dir && echo "in a subshell: $i" ) && echo "still here"
+{ echo grouped; false; } || echo "the group failed"
+! true || echo unreachable
+cat ascii.txt | wc -l
+true && false || echo "and-or fell through"
+echo "$((7 * 6)) $((1 << 4)) $((10 % 4)) $((2 > 1 ? 5 : 6))"
+test -d dir && [ -f ascii.txt ] && [ ! -e missing ] && echo "tests pass"
+echo done
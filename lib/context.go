@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Context is everything an applet would otherwise take from the process: its
+// standard streams, working directory and environment.  Applets only ever
+// use the Context they are given, so they can run in-process, concurrently,
+// with their output captured.
+type Context struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Dir is the working directory relative paths are resolved against.
+	// Empty means the process's working directory.
+	Dir string
+	// Env is the environment, as KEY=value strings like os.Environ
+	Env []string
+	// Fds are any descriptors open past Stderr, by number: an *os.File, or
+	// an io.Reader or io.Writer.  Only applets that pass descriptors on to
+	// what they run, like sh, look at them.
+	Fds map[int]interface{}
+	// Umask is the mask of permissions new files don't get, for applets
+	// that create files or start programs, like sh.  Nil means the
+	// process's.
+	Umask *int
+}
+
+// OSContext is the context of this process
+func OSContext() *Context {
+	return &Context{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Env:    os.Environ(),
+	}
+}
+
+// Getenv retrieves the value of the environment variable named by the key,
+// or "" if it isn't set.  Later entries win, like os/exec.
+func (c *Context) Getenv(key string) string {
+	value := ""
+	for _, kv := range c.Env {
+		if strings.HasPrefix(kv, key) && len(kv) > len(key) && kv[len(key)] == '=' {
+			value = kv[len(key)+1:]
+		}
+	}
+	return value
+}
+
+// Path resolves name against the working directory
+func (c *Context) Path(name string) string {
+	if c.Dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(c.Dir, name)
+}
+
+// stdin doesn't get closed when the applet is done with it
+type stdin struct {
+	io.Reader
+}
+
+func (stdin) Close() error { return nil }
+
+// WriteTo lets io.Copy see the real reader, so an *os.File stdin can still
+// take the sendfile/splice fast paths
+func (s stdin) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, s.Reader)
+}
+
+// Stat the underlying reader, if it is a file, so applets can tell what
+// stdin really is
+func (s stdin) Stat() (os.FileInfo, error) {
+	if f, ok := s.Reader.(interface{ Stat() (os.FileInfo, error) }); ok {
+		return f.Stat()
+	}
+	return nil, errors.New("stdin is not a file")
+}
+
+// Seek the underlying reader, if it can, so applets can read a file on
+// stdin twice
+func (s stdin) Seek(offset int64, whence int) (int64, error) {
+	if f, ok := s.Reader.(io.Seeker); ok {
+		return f.Seek(offset, whence)
+	}
+	return 0, errors.New("stdin is not seekable")
+}
+
+// ParseFiles opens filename, where "-" is standard input.  It is always safe
+// to Close the result.
+func (c *Context) ParseFiles(filename string) (string, io.ReadCloser, error) {
+	if filename == "-" {
+		return "/dev/stdin", stdin{c.Stdin}, nil
+	}
+	fd, err := os.Open(c.Path(filename))
+	return filename, fd, err
+}
@@ -0,0 +1,52 @@
+package lib_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+func TestGetenv(t *testing.T) {
+	ctx := &lib.Context{Env: []string{"LANG=C", "LANGUAGE=en", "HOME=/root", "LANG=C.UTF-8"}}
+	var tests = []struct {
+		key, expected string
+	}{
+		{"LANG", "C.UTF-8"},
+		{"LANGUAGE", "en"},
+		{"HOME", "/root"},
+		{"LAN", ""},
+		{"PATH", ""},
+	}
+	for _, tt := range tests {
+		if actual := ctx.Getenv(tt.key); actual != tt.expected {
+			t.Errorf("Getenv(%q): expected %q, actual %q", tt.key, tt.expected, actual)
+		}
+	}
+}
+
+func TestParseFilesUsesContext(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("from a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &lib.Context{Stdin: strings.NewReader("from stdin"), Dir: dir}
+
+	for filename, expected := range map[string]string{"-": "from stdin", "a": "from a"} {
+		_, in, err := ctx.ParseFiles(filename)
+		if err != nil {
+			t.Fatalf("%s: %s", filename, err)
+		}
+		actual, err := io.ReadAll(in)
+		in.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", filename, err)
+		}
+		if string(actual) != expected {
+			t.Errorf("%s: expected %q, actual %q", filename, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Package decompress is the input layer behind the -z/--decompress the
+// applets that read files have: it tells what a file was compressed with
+// from its first bytes and hands back a reader of what's in it.  Files that
+// aren't compressed come back as they are, so one command line can take
+// both, like wc -lz logs/*.
+package decompress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// Flag and Shorthand are the option each applet turns decompression on with
+const (
+	Flag      = "decompress"
+	Shorthand = "z"
+)
+
+// Format is what a file was compressed with
+type Format int
+
+const (
+	// None is a file that isn't compressed, or not in a format we know
+	None Format = iota
+	Gzip
+	Bzip2
+	Zlib
+	XZ
+	Zstd
+)
+
+func (f Format) String() string {
+	switch f {
+	case Gzip:
+		return "gzip"
+	case Bzip2:
+		return "bzip2"
+	case Zlib:
+		return "zlib"
+	case XZ:
+		return "xz"
+	case Zstd:
+		return "zstd"
+	}
+	return "none"
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+const (
+	// magicSize is the longest magic number
+	magicSize = 6
+	// SniffSize is how much of a file Sniff looks at, for the zlib check
+	SniffSize = 512
+)
+
+// Sniff is the format of a file that starts with head, which is all of it
+// if it's shorter than SniffSize.  All the formats but zlib start with a
+// magic number; zlib's two byte header is a checksum of itself that plenty
+// of text passes, "x^" for one, so head has to inflate too, as far as it
+// goes.
+func Sniff(head []byte) Format {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(head, xzMagic):
+		return XZ
+	case bytes.HasPrefix(head, zstdMagic):
+		return Zstd
+	case len(head) >= 4 && string(head[:3]) == "BZh" && head[3] >= '1' && head[3] <= '9':
+		return Bzip2
+	case zlibHeader(head) && inflates(head):
+		return Zlib
+	}
+	return None
+}
+
+// zlibHeader is head starting with a zlib header: deflate, without a preset
+// dictionary
+func zlibHeader(head []byte) bool {
+	return len(head) >= 2 && head[0]&0x0f == 8 && head[0]>>4 <= 7 && head[1]&0x20 == 0 &&
+		(uint(head[0])<<8|uint(head[1]))%31 == 0
+}
+
+// inflates is head inflating without an error, and to the end if it's the
+// whole file
+func inflates(head []byte) bool {
+	r, err := zlib.NewReader(bytes.NewReader(head))
+	if err == nil {
+		_, err = io.Copy(io.Discard, r)
+	}
+	return err == nil || err == io.ErrUnexpectedEOF && len(head) >= SniffSize
+}
+
+// decoders are the formats that need more than the standard library, which
+// their own files add when they're built in
+var decoders = map[Format]func(io.Reader) (io.Reader, error){}
+
+// Supported is f being one NewReader can decompress.  Sniff knows them all
+// either way, so a file in a format left out of the build is an error rather
+// than passed through compressed.
+func Supported(f Format) bool {
+	switch f {
+	case None, Gzip, Bzip2, Zlib:
+		return true
+	}
+	_, ok := decoders[f]
+	return ok
+}
+
+// NewReader reads what's in in, decompressing it if it's compressed, and
+// closes in when it's closed.  A file that isn't compressed is in itself,
+// put back where it was, so applets can still stat, seek or sendfile it.
+func NewReader(in io.ReadCloser) (io.ReadCloser, error) {
+	head, in, err := peek(in)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	switch format := Sniff(head); format {
+	case None:
+		return in, nil
+	case Gzip:
+		r, err = gzip.NewReader(in)
+	case Bzip2:
+		r = bzip2.NewReader(in)
+	case Zlib:
+		r, err = zlib.NewReader(in)
+	default:
+		decoder, ok := decoders[format]
+		if !ok {
+			return nil, fmt.Errorf("%s support not built in", format)
+		}
+		r, err = decoder(in)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readCloser{r, in}, nil
+}
+
+// readCloser reads from Reader, and closes it and then the file under it
+type readCloser struct {
+	io.Reader
+	file io.Closer
+}
+
+func (r readCloser) Close() error {
+	if closer, ok := r.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return r.file.Close()
+}
+
+// peek at the start of in without using it up.  A file is read and seeked
+// back; anything else is buffered, reading no more than it has to for the
+// magic numbers, so a terminal isn't kept waiting, unless it might be zlib.
+func peek(in io.ReadCloser) ([]byte, io.ReadCloser, error) {
+	if seeker, ok := in.(io.Seeker); ok {
+		if at, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			head := make([]byte, SniffSize)
+			n, err := io.ReadFull(in, head)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return nil, in, err
+			}
+			if _, err := seeker.Seek(at, io.SeekStart); err != nil {
+				return nil, in, err
+			}
+			return head[:n], in, nil
+		}
+	}
+
+	buffered := bufio.NewReaderSize(in, SniffSize)
+	for n := 1; ; n = buffered.Buffered() + 1 {
+		head, err := buffered.Peek(n)
+		if err == io.EOF || len(head) == SniffSize ||
+			err == nil && len(head) >= magicSize && !zlibHeader(head) {
+			head, _ = buffered.Peek(buffered.Buffered())
+			return head, readCloser{buffered, in}, nil
+		}
+		if err != nil {
+			return nil, in, err
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package decompress_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+	"gitlab.com/yarbelk/slimbox/lib/decompress"
+)
+
+const hello = "hello\nworld\n"
+
+func TestFormats(t *testing.T) {
+	var tests = []struct {
+		file     string
+		format   decompress.Format
+		expected string
+	}{
+		{"hello.gz", decompress.Gzip, hello},
+		{"hello.bz2", decompress.Bzip2, hello},
+		{"hello.zlib", decompress.Zlib, hello},
+		{"hello.xz", decompress.XZ, hello},
+		{"hello.zst", decompress.Zstd, hello},
+		{"twice.gz", decompress.Gzip, hello + hello},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.file, func(t *testing.T) {
+			compressed, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format := decompress.Sniff(compressed); format != tt.format {
+				t.Errorf("expected %s, actual %s", tt.format, format)
+			}
+
+			// from a file, and from a pipe a byte at a time
+			ctx := &lib.Context{Dir: "testdata", Stdin: iotest.OneByteReader(bytes.NewReader(compressed))}
+			for _, name := range []string{tt.file, "-"} {
+				in, err := open(t, ctx, name)
+				if !decompress.Supported(tt.format) {
+					if expected := tt.format.String() + " support not built in"; err == nil || err.Error() != expected {
+						t.Errorf("%s: expected %q, actual %v", name, expected, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s: %s", name, err)
+				}
+				actual, err := io.ReadAll(in)
+				in.Close()
+				if err != nil {
+					t.Fatalf("%s: %s", name, err)
+				}
+				if string(actual) != tt.expected {
+					t.Errorf("%s: expected %q, actual %q", name, tt.expected, actual)
+				}
+			}
+		})
+	}
+}
+
+// Anything that isn't compressed comes through as it is, zlib's header
+// lookalikes included
+func TestNotCompressed(t *testing.T) {
+	for _, given := range []string{"", "x", "BZ", "BZh0 no", "\x1f", "x^2\n", "x^2 is a square\n",
+		strings.Repeat("x^2 is a square\n", 100)} {
+		if format := decompress.Sniff([]byte(given)); format != decompress.None {
+			t.Errorf("%q: expected none, actual %s", given, format)
+		}
+		ctx := &lib.Context{Stdin: iotest.OneByteReader(strings.NewReader(given))}
+		in, err := open(t, ctx, "-")
+		if err != nil {
+			t.Fatalf("%q: %s", given, err)
+		}
+		actual, err := io.ReadAll(in)
+		if err != nil || string(actual) != given {
+			t.Errorf("expected %q, actual %q, %v", given, actual, err)
+		}
+	}
+}
+
+// A file that isn't compressed is the file itself, where it was, so the
+// applets' fast paths for files still work
+func TestFilesStayFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plain")
+	if err := os.WriteFile(path, []byte("plain text\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	in, err := decompress.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in != io.ReadCloser(f) {
+		t.Errorf("expected the file, actual %T", in)
+	}
+	if rest, _ := io.ReadAll(in); string(rest) != "text\n" {
+		t.Errorf("expected to carry on from where it was, actual %q", rest)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	compressed, err := os.ReadFile(filepath.Join("testdata", "hello.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := decompress.NewReader(io.NopCloser(bytes.NewReader(compressed[:len(compressed)-4])))
+	if err == nil {
+		_, err = io.ReadAll(in)
+	}
+	if err == nil {
+		t.Error("expected a truncated file to fail")
+	}
+}
+
+// open name the way the applets do with -z
+func open(t *testing.T, ctx *lib.Context, name string) (io.ReadCloser, error) {
+	t.Helper()
+	_, f, err := ctx.ParseFiles(name)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	in, err := decompress.NewReader(f)
+	if err != nil {
+		f.Close()
+	}
+	return in, err
+}
@@ -0,0 +1,16 @@
+//go:build !slim || decompress_xz
+// +build !slim decompress_xz
+
+package decompress
+
+import (
+	"io"
+
+	"github.com/ulikunitz/xz"
+)
+
+func init() {
+	decoders[XZ] = func(in io.Reader) (io.Reader, error) {
+		return xz.NewReader(in)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !slim || decompress_zstd
+// +build !slim decompress_zstd
+
+package decompress
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func init() {
+	decoders[Zstd] = func(in io.Reader) (io.Reader, error) {
+		d, err := zstd.NewReader(in, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Exit statuses, as POSIX utilities use them
+const (
+	ExitSuccess = 0
+	// ExitFailure is for when an operand couldn't be processed
+	ExitFailure = 1
+	// ExitUsage is for bad flags or arguments
+	ExitUsage = 2
+)
+
+// Error is an applet failing on one operand (or on its usage if there is no
+// File).  It prints the way the GNU tools do:
+//
+//	cat: missing: No such file or directory
+type Error struct {
+	Applet string
+	File   string
+	Err    error
+	Status int
+}
+
+// OperandError is applet failing to process file; it will keep going with
+// the rest, and exit ExitFailure
+func OperandError(applet, file string, err error) *Error {
+	return &Error{Applet: applet, File: file, Err: err, Status: ExitFailure}
+}
+
+// UsageError is applet being called wrong, and exits ExitUsage
+func UsageError(applet string, err error) *Error {
+	return &Error{Applet: applet, Err: err, Status: ExitUsage}
+}
+
+func (e *Error) Error() string {
+	builder := strings.Builder{}
+	builder.WriteString(e.Applet)
+	builder.WriteString(": ")
+	if e.File != "" {
+		builder.WriteString(e.File)
+		builder.WriteString(": ")
+	}
+	builder.WriteString(e.Reason())
+	return builder.String()
+}
+
+// Reason is why it failed, without the applet or file
+func (e *Error) Reason() string {
+	return reason(e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// reason drops the op and path the os package wraps around a syscall error,
+// since Error already names the file, and capitalises it like strerror does
+func reason(err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return err.Error()
+	}
+	msg := errno.Error()
+	r, size := utf8.DecodeRuneInString(msg)
+	return string(unicode.ToUpper(r)) + msg[size:]
+}
+
+// Errors are all the errors an applet hit while it kept going
+type Errors []error
+
+func (es Errors) Error() string {
+	builder := strings.Builder{}
+	for _, e := range es {
+		builder.WriteString(e.Error())
+		builder.WriteRune('\n')
+	}
+	return builder.String()
+}
+
+// ExitCode is the status an applet should exit with after err: the highest
+// Status of any *Error in it, ExitFailure for anything else, and ExitSuccess
+// for nil.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	if es, ok := err.(Errors); ok {
+		status := ExitSuccess
+		for _, e := range es {
+			if s := ExitCode(e); s > status {
+				status = s
+			}
+		}
+		return status
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Status
+	}
+	return ExitFailure
+}
+
+// Report writes err to w, one line per error, and returns the status to exit with
+func Report(w io.Writer, err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	if es, ok := err.(Errors); ok {
+		for _, e := range es {
+			fmt.Fprintln(w, e)
+		}
+	} else {
+		fmt.Fprintln(w, err)
+	}
+	return ExitCode(err)
+}
@@ -0,0 +1,60 @@
+package lib_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+func TestErrorFormatting(t *testing.T) {
+	_, missing := os.Open("/this/does/not/exist")
+	_, dir := os.ReadFile(".")
+
+	var tests = []struct {
+		name     string
+		err      error
+		expected string
+		status   int
+	}{
+		{"missing file", lib.OperandError("cat", "missing", missing), "cat: missing: No such file or directory", lib.ExitFailure},
+		{"directory", lib.OperandError("wc", ".", dir), "wc: .: Is a directory", lib.ExitFailure},
+		{"not a syscall error", lib.OperandError("wc", "-", errors.New("token too long")), "wc: -: token too long", lib.ExitFailure},
+		{"usage", lib.UsageError("cat", errors.New("unknown flag: --bogus")), "cat: unknown flag: --bogus", lib.ExitUsage},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.err.Error(); actual != tt.expected {
+				t.Errorf("expected %q, actual %q", tt.expected, actual)
+			}
+			if actual := lib.ExitCode(tt.err); actual != tt.status {
+				t.Errorf("expected status %d, actual %d", tt.status, actual)
+			}
+		})
+	}
+}
+
+func TestReportErrors(t *testing.T) {
+	_, missing := os.Open("/this/does/not/exist")
+	errs := lib.Errors{
+		lib.OperandError("cat", "a", missing),
+		lib.UsageError("cat", errors.New("extra operand")),
+		lib.OperandError("cat", "b", missing),
+	}
+	stderr := &bytes.Buffer{}
+	status := lib.Report(stderr, errs)
+
+	expected := "cat: a: No such file or directory\ncat: extra operand\ncat: b: No such file or directory\n"
+	if stderr.String() != expected {
+		t.Errorf("expected %q, actual %q", expected, stderr)
+	}
+	if status != lib.ExitUsage {
+		t.Errorf("expected the worst status %d, actual %d", lib.ExitUsage, status)
+	}
+	if actual := lib.Report(stderr, nil); actual != lib.ExitSuccess {
+		t.Errorf("expected success for no error, actual %d", actual)
+	}
+}
@@ -0,0 +1,13 @@
+package lib
+
+// UnregisterApplet undoes RegisterApplet, so tests can register their own
+// applets without leaving them behind for the rest of the package
+func UnregisterApplet(function string) {
+	delete(applets, function)
+	for i, fn := range registry {
+		if fn == function {
+			registry = append(registry[:i:i], registry[i+1:]...)
+			return
+		}
+	}
+}
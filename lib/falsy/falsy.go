@@ -3,11 +3,35 @@ package falsy
 import (
 	"os"
 
+	"github.com/spf13/pflag"
 	"gitlab.com/yarbelk/slimbox/lib"
 )
 
 func init() {
-	lib.RegisterFunction("false")
+	lib.RegisterApplet(func() lib.Applet { return applet{} })
+}
+
+type applet struct{}
+
+func (applet) Name() string    { return "false" }
+func (applet) Summary() string { return "do nothing, unsuccessfully" }
+
+func (applet) Usage() lib.Usage {
+	return lib.Usage{
+		Operands:    "[ignored command line arguments]",
+		Description: "Exit with a status code indicating failure.",
+	}
+}
+
+// FlagSet accepts anything; false ignores its arguments
+func (applet) FlagSet() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("false", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	return fs
+}
+
+func (applet) Run(ctx *lib.Context, args []string) int {
+	return 1
 }
 
 // False is only False.  it only exits badly
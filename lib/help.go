@@ -0,0 +1,162 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spf13/pflag"
+)
+
+// Usage is what an applet says about itself in --help, around its flags
+type Usage struct {
+	// Operands go after `Usage: NAME [OPTION]...`, eg "[FILE]..."
+	Operands string
+	// Description is printed before the flags.  Defaults to the summary.
+	Description string
+	// Epilogue is printed after the flags
+	Epilogue string
+}
+
+// Documented applets have more to say in --help than their summary
+type Documented interface {
+	Usage() Usage
+}
+
+// Version of slimbox this was built from, from the module's build info
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if revision != "" && !strings.Contains(version, revision) {
+		version = fmt.Sprintf("%s (%s%s)", version, revision, modified)
+	}
+	return version
+}
+
+// PrintVersion writes the --version output for function to w
+func PrintVersion(w io.Writer, function string) {
+	fmt.Fprintf(w, "%s (slimbox) %s\n", function, Version())
+}
+
+// Help writes the --help output for function to w
+func Help(w io.Writer, function string) error {
+	newApplet, ok := applets[function]
+	if !ok {
+		return fmt.Errorf("%s: applet not found", function)
+	}
+	applet := newApplet()
+	fs := applet.FlagSet()
+	addCommonFlags(fs, new(bool), new(bool))
+	printHelp(w, applet, fs)
+	return nil
+}
+
+// addCommonFlags gives every applet --help and --version, unless it has its own
+func addCommonFlags(fs *pflag.FlagSet, help, version *bool) {
+	if fs.Lookup("help") == nil {
+		fs.BoolVar(help, "help", false, "display this help and exit")
+	}
+	if fs.Lookup("version") == nil {
+		fs.BoolVar(version, "version", false, "output version information and exit")
+	}
+}
+
+// shorthandOnly is the annotation ShorthandOnly marks flags with
+const shorthandOnly = "slimbox_shorthand_only"
+
+// ShorthandOnly marks the flag called name in fs as having no long option,
+// like cat's -e.  pflag wants every flag to have a name; this one is only
+// for looking it up, so --name is an unknown flag and --help leaves it out.
+func ShorthandOnly(fs *pflag.FlagSet, name string) {
+	fs.SetAnnotation(name, shorthandOnly, []string{"true"})
+}
+
+func isShorthandOnly(flag *pflag.Flag) bool {
+	_, ok := flag.Annotations[shorthandOnly]
+	return ok
+}
+
+// checkShorthandOnly fails on a long option in args for a flag that only
+// has a shorthand, the way pflag fails on one it has never heard of.  It is
+// run once fs has parsed args, to leave out the operands fs stopped at if it
+// doesn't intersperse them with flags, like sh's script and its arguments.
+func checkShorthandOnly(fs *pflag.FlagSet, args []string) error {
+	if operands := fs.Args(); len(operands) <= len(args) && slices.Equal(operands, args[len(args)-len(operands):]) {
+		args = args[:len(args)-len(operands)]
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return nil
+		}
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		name := strings.SplitN(arg[2:], "=", 2)[0]
+		flag := fs.Lookup(name)
+		switch {
+		case flag == nil:
+		case isShorthandOnly(flag):
+			return fmt.Errorf("unknown flag: --%s", name)
+		case flag.NoOptDefVal == "" && !strings.Contains(arg, "="):
+			// the next argument is this one's value
+			i++
+		}
+	}
+	return nil
+}
+
+// flagUsages is fs.FlagUsages, with the long options shorthand only flags
+// don't have blanked out, so the columns stay lined up
+func flagUsages(fs *pflag.FlagSet) string {
+	usages := fs.FlagUsages()
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if isShorthandOnly(flag) {
+			long := fmt.Sprintf("-%s, --%s ", flag.Shorthand, flag.Name)
+			usages = strings.Replace(usages, long, "-"+flag.Shorthand+strings.Repeat(" ", len(long)-1-len(flag.Shorthand)), 1)
+		}
+	})
+	return usages
+}
+
+func printHelp(w io.Writer, applet Applet, fs *pflag.FlagSet) {
+	usage := Usage{}
+	if documented, ok := applet.(Documented); ok {
+		usage = documented.Usage()
+	}
+	if usage.Description == "" {
+		r, size := utf8.DecodeRuneInString(applet.Summary())
+		usage.Description = string(unicode.ToUpper(r)) + applet.Summary()[size:] + "."
+	}
+
+	fmt.Fprintf(w, "Usage: %s [OPTION]...", applet.Name())
+	if usage.Operands != "" {
+		fmt.Fprintf(w, " %s", usage.Operands)
+	}
+	fmt.Fprintf(w, "\n%s\n\n", strings.TrimRight(usage.Description, "\n"))
+	fmt.Fprint(w, flagUsages(fs))
+	if usage.Epilogue != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimRight(usage.Epilogue, "\n"))
+	}
+}
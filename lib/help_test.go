@@ -0,0 +1,56 @@
+package lib_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+func TestCommonHelpAndVersion(t *testing.T) {
+	registerEchoStatus(t)
+
+	var tests = []struct {
+		name   string
+		args   []string
+		prefix string
+	}{
+		{"--help", []string{"--help"}, "Usage: echo-status [OPTION]...\nExit with the status given by --status.\n\n"},
+		{"-h", []string{"-h"}, "Usage: echo-status [OPTION]...\n"},
+		{"--version", []string{"--version"}, "echo-status (slimbox) "},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			ctx := &lib.Context{Stdout: stdout, Stderr: stderr}
+			if status := lib.RunContext(ctx, "echo-status", append(tt.args, "--status", "3")); status != lib.ExitSuccess {
+				t.Errorf("expected exit 0 rather than running, actual %d", status)
+			}
+			if !strings.HasPrefix(stdout.String(), tt.prefix) {
+				t.Errorf("expected to start with %q, actual %q", tt.prefix, stdout)
+			}
+			if stderr.Len() != 0 {
+				t.Errorf("expected nothing on stderr, actual %q", stderr)
+			}
+		})
+	}
+}
+
+func TestHelpListsCommonFlags(t *testing.T) {
+	registerEchoStatus(t)
+
+	stdout := &bytes.Buffer{}
+	if err := lib.Help(stdout, "echo-status"); err != nil {
+		t.Fatal(err)
+	}
+	for _, flag := range []string{"--status", "--help", "--version"} {
+		if !strings.Contains(stdout.String(), flag) {
+			t.Errorf("expected %s in %q", flag, stdout)
+		}
+	}
+	if err := lib.Help(stdout, "no-such-applet"); err == nil {
+		t.Error("expected an error for an unknown applet")
+	}
+}
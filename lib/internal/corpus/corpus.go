@@ -0,0 +1,72 @@
+// Package corpus makes synthetic inputs for the benchmarks, so they are the
+// same on every machine and don't need anything checked in.
+package corpus
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Size of each generated corpus, in bytes (roughly)
+const Size = 8 << 20
+
+var (
+	asciiWords = []string{"the", "quick", "brown", "fox", "jumps", "over", "lazy", "dog", "slimbox", "a", "I", "busybox"}
+	cjkWords   = []string{"你好", "世界", "こんにちは", "東京", "안녕하세요", "汉字", "测试", "文字"}
+)
+
+// Corpus is one kind of input
+type Corpus struct {
+	Name string
+	Data []byte
+}
+
+func text(words []string, size, wordsPerLine int) []byte {
+	r := rand.New(rand.NewSource(1))
+	buf := bytes.Buffer{}
+	buf.Grow(size + 1024)
+	for buf.Len() < size {
+		for i := 0; i < wordsPerLine; i++ {
+			if i != 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(words[r.Intn(len(words))])
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// ASCII is English-ish text, ten words to a line
+func ASCII() Corpus { return Corpus{"ascii", text(asciiWords, Size, 10)} }
+
+// CJK is multibyte UTF-8 text, mostly wide characters
+func CJK() Corpus { return Corpus{"cjk", text(cjkWords, Size, 10)} }
+
+// LongLines is ASCII text with lines of around a megabyte
+func LongLines() Corpus { return Corpus{"long-lines", text(asciiWords, Size, 200000)} }
+
+// All the single file corpora
+func All() []Corpus {
+	return []Corpus{ASCII(), CJK(), LongLines()}
+}
+
+// SmallFiles writes n small ASCII files into a temp dir and returns their
+// names, relative to that dir, and the total size
+func SmallFiles(b testing.TB, n int) (dir string, names []string, size int64) {
+	dir = b.TempDir()
+	data := text(asciiWords, 4096, 10)
+	for i := 0; i < n; i++ {
+		name := strconv.Itoa(i) + ".txt"
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			b.Fatal(err)
+		}
+		names = append(names, name)
+		size += int64(len(data))
+	}
+	return dir, names, size
+}
@@ -1,8 +1,28 @@
 package lib
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// Applet is a single invocation of a function.  A new one is made every time
+// the function is run, so its options never leak between runs.
+type Applet interface {
+	// Name the applet is called by; `slimbox NAME` or a symlink called NAME
+	Name() string
+	// Summary is a one line description for listings
+	Summary() string
+	// FlagSet binds the applet's options to a new flag set
+	FlagSet() *pflag.FlagSet
+	// Run the applet on the arguments left over after flag parsing, and
+	// return its exit code.  Everything it reads or writes goes through ctx.
+	Run(ctx *Context, args []string) int
+}
 
 var registry Functions = make(Functions, 0)
+var applets = make(map[string]func() Applet)
 
 type Functions []string
 
@@ -17,12 +37,24 @@ func (f Functions) String() string {
 	return builder.String()
 }
 
-func RegisterFunction(function string) {
-	for _, fn := range registry {
+// Contains reports if function is one of f
+func (f Functions) Contains(function string) bool {
+	for _, fn := range f {
 		if fn == function {
-			return
+			return true
 		}
 	}
+	return false
+}
+
+// RegisterApplet makes the applet built by newApplet available to Run under
+// its Name.  Call it from the applet package's init.
+func RegisterApplet(newApplet func() Applet) {
+	function := newApplet().Name()
+	applets[function] = newApplet
+	if registry.Contains(function) {
+		return
+	}
 	registry = append(registry, function)
 }
 
@@ -30,3 +62,61 @@ func RegisterFunction(function string) {
 func RegisteredFunctions() Functions {
 	return registry[:]
 }
+
+// Summary of the registered function, or "" if there isn't one
+func Summary(function string) string {
+	newApplet, ok := applets[function]
+	if !ok {
+		return ""
+	}
+	return newApplet().Summary()
+}
+
+// Run parses args with a new instance of the function's applet, runs it in
+// this process's context, and returns its exit code.  This is the same for the
+// fat binary and the stand alone ones in cmd/
+func Run(function string, args []string) int {
+	return RunContext(OSContext(), function, args)
+}
+
+// RunContext is Run with the streams, directory and environment taken from ctx.
+// A nil Stdin reads as empty.
+func RunContext(ctx *Context, function string, args []string) int {
+	newApplet, ok := applets[function]
+	if !ok {
+		fmt.Fprintf(ctx.Stderr, "%s: applet not found\n", function)
+		return 127
+	}
+	if ctx.Stdin == nil {
+		empty := *ctx
+		empty.Stdin = strings.NewReader("")
+		ctx = &empty
+	}
+	applet := newApplet()
+	fs := applet.FlagSet()
+	fs.SetOutput(ctx.Stderr)
+	fs.Usage = func() { printHelp(ctx.Stdout, applet, fs) }
+	var help, version bool
+	addCommonFlags(fs, &help, &version)
+	err := fs.Parse(args)
+	if err == nil {
+		err = checkShorthandOnly(fs, args)
+	}
+	if err != nil {
+		if err == pflag.ErrHelp {
+			return ExitSuccess
+		}
+		status := Report(ctx.Stderr, UsageError(function, err))
+		fmt.Fprintf(ctx.Stderr, "Try '%s --help' for more information.\n", function)
+		return status
+	}
+	switch {
+	case help:
+		fs.Usage()
+		return ExitSuccess
+	case version:
+		PrintVersion(ctx.Stdout, function)
+		return ExitSuccess
+	}
+	return applet.Run(ctx, fs.Args())
+}
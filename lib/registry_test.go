@@ -0,0 +1,103 @@
+package lib_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+type echoStatus struct {
+	status int
+}
+
+func (e *echoStatus) Name() string    { return "echo-status" }
+func (e *echoStatus) Summary() string { return "exit with the status given by --status" }
+func (e *echoStatus) FlagSet() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("echo-status", pflag.ContinueOnError)
+	fs.IntVar(&e.status, "status", 0, "status to exit with")
+	return fs
+}
+func (e *echoStatus) Run(ctx *lib.Context, args []string) int { return e.status }
+
+// readStdin copies standard input to standard output
+type readStdin struct{}
+
+func (readStdin) Name() string    { return "read-stdin" }
+func (readStdin) Summary() string { return "copy standard input to standard output" }
+func (readStdin) FlagSet() *pflag.FlagSet {
+	return pflag.NewFlagSet("read-stdin", pflag.ContinueOnError)
+}
+func (readStdin) Run(ctx *lib.Context, args []string) int {
+	_, in, err := ctx.ParseFiles("-")
+	if err == nil {
+		_, err = io.Copy(ctx.Stdout, in)
+		in.Close()
+	}
+	if err != nil {
+		return lib.Report(ctx.Stderr, err)
+	}
+	return lib.ExitSuccess
+}
+
+// registerEchoStatus registers echo-status for the length of the test
+func registerEchoStatus(t *testing.T) {
+	t.Helper()
+	lib.RegisterApplet(func() lib.Applet { return &echoStatus{} })
+	t.Cleanup(func() { lib.UnregisterApplet("echo-status") })
+}
+
+func TestRegisterAndRun(t *testing.T) {
+	registerEchoStatus(t)
+
+	if !lib.RegisteredFunctions().Contains("echo-status") {
+		t.Fatalf("expected echo-status in %s", lib.RegisteredFunctions())
+	}
+	if actual := lib.Summary("echo-status"); actual != "exit with the status given by --status" {
+		t.Errorf("unexpected summary %q", actual)
+	}
+	if actual := lib.Run("echo-status", []string{"--status", "3"}); actual != 3 {
+		t.Errorf("expected exit 3, actual %d", actual)
+	}
+	// A fresh applet is built each run, so the last run's flags don't stick
+	if actual := lib.Run("echo-status", nil); actual != 0 {
+		t.Errorf("expected exit 0, actual %d", actual)
+	}
+
+	stderr := &bytes.Buffer{}
+	ctx := &lib.Context{Stdout: &bytes.Buffer{}, Stderr: stderr}
+	if actual := lib.RunContext(ctx, "echo-status", []string{"--bogus"}); actual != lib.ExitUsage {
+		t.Errorf("expected exit %d for a bad flag, actual %d", lib.ExitUsage, actual)
+	}
+	if !strings.HasPrefix(stderr.String(), "echo-status: unknown flag: --bogus\n") {
+		t.Errorf("unexpected usage error %q", stderr)
+	}
+}
+
+func TestUnregisterOnCleanup(t *testing.T) {
+	t.Run("registered", registerEchoStatus)
+	if lib.RegisteredFunctions().Contains("echo-status") {
+		t.Errorf("expected echo-status gone from %s", lib.RegisteredFunctions())
+	}
+	if actual := lib.Summary("echo-status"); actual != "" {
+		t.Errorf("expected no summary, actual %q", actual)
+	}
+}
+
+// Embedding applets doesn't mean giving them a standard input
+func TestNilStdinIsEmpty(t *testing.T) {
+	lib.RegisterApplet(func() lib.Applet { return readStdin{} })
+	t.Cleanup(func() { lib.UnregisterApplet("read-stdin") })
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx := &lib.Context{Stdout: stdout, Stderr: stderr}
+	if status := lib.RunContext(ctx, "read-stdin", nil); status != lib.ExitSuccess || stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("expected empty input, actual exit %d, %q and %q", status, stdout, stderr)
+	}
+	if ctx.Stdin != nil {
+		t.Error("expected the caller's context left as it was")
+	}
+}
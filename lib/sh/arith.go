@@ -0,0 +1,273 @@
+package sh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// arithmetic works out expr, from $((expr)), with the C operators POSIX
+// asks for on signed 64 bit integers.  Variables in it are read as numbers,
+// and an unset or empty one is 0.
+func (s *Shell) arithmetic(expr string) (n int64, err error) {
+	a := &arith{s: s, src: expr}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(arithError)
+			if !ok {
+				panic(r)
+			}
+			err = e.err
+		}
+	}()
+	a.next()
+	n = a.assign(true)
+	if a.tok != "" {
+		a.fail("expecting EOF")
+	}
+	return n, nil
+}
+
+type arithError struct{ err error }
+
+type arith struct {
+	s   *Shell
+	src string
+	off int
+	// tok is the token being looked at: an operator, a number or a name,
+	// and "" at the end
+	tok string
+}
+
+func (a *arith) fail(msg string) {
+	panic(arithError{expandErrorf("arithmetic expression: %s: %q", msg, a.src)})
+}
+
+// operators, longest first so the lexer takes the longest one that fits
+var arithOps = []string{
+	"<<=", ">>=",
+	"<<", ">>", "<=", ">=", "==", "!=", "&&", "||", "+=", "-=", "*=", "/=", "%=", "&=", "^=", "|=",
+	"+", "-", "*", "/", "%", "<", ">", "&", "^", "|", "!", "~", "?", ":", "=", "(", ")",
+}
+
+func (a *arith) next() {
+	for a.off < len(a.src) && strings.IndexByte(" \t\n", a.src[a.off]) >= 0 {
+		a.off++
+	}
+	if a.off == len(a.src) {
+		a.tok = ""
+		return
+	}
+	start := a.off
+	if c := a.src[a.off]; c >= '0' && c <= '9' || isNameStart(c) {
+		for a.off < len(a.src) && isNameChar(a.src[a.off]) {
+			a.off++
+		}
+		a.tok = a.src[start:a.off]
+		return
+	}
+	for _, op := range arithOps {
+		if strings.HasPrefix(a.src[a.off:], op) {
+			a.off += len(op)
+			a.tok = op
+			return
+		}
+	}
+	// anything else is a token nothing takes, for the parser to fail on
+	a.tok = a.src[a.off : a.off+1]
+	a.off++
+}
+
+var arithAssigns = map[string]bool{
+	"=": true, "+=": true, "-=": true, "*=": true, "/=": true, "%=": true,
+	"<<=": true, ">>=": true, "&=": true, "^=": true, "|=": true,
+}
+
+// binary operators' precedence, tightest last
+var arithPrec = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+// assign is the lowest precedence: name = expr and the like, then ?:.
+// Nothing is changed or divided where eval is false, in the side of a
+// && || or ?: that isn't taken.
+func (a *arith) assign(eval bool) int64 {
+	start, tok := a.off, a.tok
+	if IsName(tok) {
+		a.next()
+		if op := a.tok; arithAssigns[op] {
+			a.next()
+			value := a.assign(eval)
+			if op != "=" {
+				value = a.apply(op[:len(op)-1], a.variable(tok, eval), value, eval)
+			}
+			if eval {
+				if err := a.s.setVar(tok, strconv.FormatInt(value, 10)); err != nil {
+					panic(arithError{&expandError{err.Error()}})
+				}
+			}
+			return value
+		}
+		a.off, a.tok = start, tok
+	}
+	return a.conditional(eval)
+}
+
+func (a *arith) conditional(eval bool) int64 {
+	cond := a.binary(1, eval)
+	if a.tok != "?" {
+		return cond
+	}
+	a.next()
+	yes := a.assign(eval && cond != 0)
+	if a.tok != ":" {
+		a.fail("expecting ':'")
+	}
+	a.next()
+	no := a.conditional(eval && cond == 0)
+	if cond != 0 {
+		return yes
+	}
+	return no
+}
+
+// binary is precedence climbing over the binary operators at least as
+// tight as prec
+func (a *arith) binary(prec int, eval bool) int64 {
+	left := a.unary(eval)
+	for {
+		op := a.tok
+		p, ok := arithPrec[op]
+		if !ok || p < prec {
+			return left
+		}
+		a.next()
+		evalRight := eval && !(op == "&&" && left == 0 || op == "||" && left != 0)
+		right := a.binary(p+1, evalRight)
+		left = a.apply(op, left, right, eval)
+	}
+}
+
+func (a *arith) apply(op string, x, y int64, eval bool) int64 {
+	b := func(ok bool) int64 {
+		if ok {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case "||":
+		return b(x != 0 || y != 0)
+	case "&&":
+		return b(x != 0 && y != 0)
+	case "|":
+		return x | y
+	case "^":
+		return x ^ y
+	case "&":
+		return x & y
+	case "==":
+		return b(x == y)
+	case "!=":
+		return b(x != y)
+	case "<":
+		return b(x < y)
+	case "<=":
+		return b(x <= y)
+	case ">":
+		return b(x > y)
+	case ">=":
+		return b(x >= y)
+	case "<<":
+		return x << uint64(y&63)
+	case ">>":
+		return x >> uint64(y&63)
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	}
+	// / and %
+	if y == 0 {
+		if !eval {
+			return 0
+		}
+		a.fail("division by zero")
+	}
+	if op == "/" {
+		return x / y
+	}
+	return x % y
+}
+
+func (a *arith) unary(eval bool) int64 {
+	switch a.tok {
+	case "+":
+		a.next()
+		return a.unary(eval)
+	case "-":
+		a.next()
+		return -a.unary(eval)
+	case "!":
+		a.next()
+		if a.unary(eval) == 0 {
+			return 1
+		}
+		return 0
+	case "~":
+		a.next()
+		return ^a.unary(eval)
+	}
+	return a.primary(eval)
+}
+
+func (a *arith) primary(eval bool) int64 {
+	tok := a.tok
+	switch {
+	case tok == "(":
+		a.next()
+		n := a.assign(eval)
+		if a.tok != ")" {
+			a.fail("expecting ')'")
+		}
+		a.next()
+		return n
+	case tok != "" && tok[0] >= '0' && tok[0] <= '9':
+		n, err := strconv.ParseInt(tok, 0, 64)
+		if err != nil {
+			a.fail("expecting primary")
+		}
+		a.next()
+		return n
+	case IsName(tok):
+		a.next()
+		return a.variable(tok, eval)
+	}
+	a.fail("expecting primary")
+	return 0
+}
+
+// variable is name's value as a number
+func (a *arith) variable(name string, eval bool) int64 {
+	value, _ := a.s.lookup(name)
+	value = strings.TrimSpace(value)
+	if value == "" || !eval {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		panic(arithError{fmt.Errorf("Illegal number: %s", value)})
+	}
+	return n
+}
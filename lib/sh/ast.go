@@ -0,0 +1,462 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Pos is where in the script a node starts; lines and columns count from 1
+type Pos struct {
+	Line, Col int
+}
+
+func (p Pos) String() string { return fmt.Sprintf("%d:%d", p.Line, p.Col) }
+
+// Node is any part of a parsed script
+type Node interface {
+	Position() Pos
+}
+
+// File is a whole script
+type File struct {
+	Name string
+	Body *List
+}
+
+func (f *File) Position() Pos { return Pos{1, 1} }
+
+// List is commands run one after another: a script, or the body of a
+// compound command
+type List struct {
+	Pos   Pos
+	Items []*AndOr
+}
+
+func (l *List) Position() Pos { return l.Pos }
+
+// AndOr is pipelines joined by && and ||, which bind equally tightly, left
+// to right.  Ops[i] joins Pipelines[i] and Pipelines[i+1].
+type AndOr struct {
+	Pipelines []*Pipeline
+	Ops       []string
+	// Async is for a trailing &: the shell doesn't wait for it
+	Async bool
+}
+
+func (a *AndOr) Position() Pos { return a.Pipelines[0].Pos }
+
+// Pipeline is commands with each one's output going to the next one's input
+type Pipeline struct {
+	Pos Pos
+	// Bang is a leading !, which inverts the status
+	Bang     bool
+	Commands []Command
+}
+
+func (p *Pipeline) Position() Pos { return p.Pos }
+
+// Command is one of the things a pipeline is made of
+type Command interface {
+	Node
+	command()
+}
+
+// Assign is NAME=value
+type Assign struct {
+	Pos   Pos
+	Name  string
+	Value *Word
+}
+
+func (a *Assign) Position() Pos { return a.Pos }
+
+// SimpleCommand is assignments, then the command name and its arguments,
+// any of which can be missing, with redirections anywhere among them
+type SimpleCommand struct {
+	Pos     Pos
+	Assigns []*Assign
+	Args    []*Word
+	Redirs  []*Redirect
+}
+
+// Redirect is a redirection of file descriptor N.  Op is one of < > >> <>
+// >| <& >& << <<-, and Word is the file, the descriptor to copy or - to
+// close N, or a here-document's delimiter.
+type Redirect struct {
+	Pos  Pos
+	N    int
+	Op   string
+	Word *Word
+	// Hdoc is a here-document's body: a double quoted string, or a single
+	// quoted one if the delimiter had quotes in it
+	Hdoc *Word
+}
+
+func (r *Redirect) Position() Pos { return r.Pos }
+
+// Redirected is a compound command with redirections after it, which only
+// last as long as it does
+type Redirected struct {
+	Pos     Pos
+	Command Command
+	Redirs  []*Redirect
+}
+
+// Subshell is ( list ), which runs in a copy of the shell
+type Subshell struct {
+	Pos  Pos
+	Body *List
+}
+
+// BraceGroup is { list; }
+type BraceGroup struct {
+	Pos  Pos
+	Body *List
+}
+
+// IfClause is if, with any elifs as Clauses after the first, and an else
+type IfClause struct {
+	Pos     Pos
+	Clauses []*CondBody
+	Else    *List
+}
+
+// CondBody is a condition and what to run if it succeeds
+type CondBody struct {
+	Cond, Body *List
+}
+
+// WhileClause is while, or until if Until is set
+type WhileClause struct {
+	Pos   Pos
+	Until bool
+	Cond  *List
+	Body  *List
+}
+
+// ForClause is for Name in Items; without "in" it goes over "$@"
+type ForClause struct {
+	Pos   Pos
+	Name  string
+	In    bool
+	Items []*Word
+	Body  *List
+}
+
+// CaseClause is case Word in, and its items
+type CaseClause struct {
+	Pos   Pos
+	Word  *Word
+	Items []*CaseItem
+}
+
+// CaseItem is patterns, and what to run if Word matches one of them
+type CaseItem struct {
+	Patterns []*Word
+	Body     *List
+}
+
+// FuncDecl is NAME() body
+type FuncDecl struct {
+	Pos  Pos
+	Name string
+	Body Command
+}
+
+func (c *SimpleCommand) Position() Pos { return c.Pos }
+func (c *Subshell) Position() Pos      { return c.Pos }
+func (c *BraceGroup) Position() Pos    { return c.Pos }
+func (c *IfClause) Position() Pos      { return c.Pos }
+func (c *WhileClause) Position() Pos   { return c.Pos }
+func (c *ForClause) Position() Pos     { return c.Pos }
+func (c *CaseClause) Position() Pos    { return c.Pos }
+func (c *FuncDecl) Position() Pos      { return c.Pos }
+func (c *Redirected) Position() Pos    { return c.Pos }
+
+func (*SimpleCommand) command() {}
+func (*Subshell) command()      {}
+func (*BraceGroup) command()    {}
+func (*IfClause) command()      {}
+func (*WhileClause) command()   {}
+func (*ForClause) command()     {}
+func (*CaseClause) command()    {}
+func (*FuncDecl) command()      {}
+func (*Redirected) command()    {}
+
+// Word is one word of a command, in the parts it is expanded from
+type Word struct {
+	Pos   Pos
+	Parts []WordPart
+}
+
+func (w *Word) Position() Pos { return w.Pos }
+
+// Lit is the word's literal text if it's nothing but, or false
+func (w *Word) Lit() (string, bool) {
+	if len(w.Parts) != 1 {
+		return "", false
+	}
+	lit, ok := w.Parts[0].(*Lit)
+	if !ok {
+		return "", false
+	}
+	return lit.Value, true
+}
+
+// WordPart is a piece of a word
+type WordPart interface {
+	wordPart()
+}
+
+// Lit is unquoted text, where globs still match
+type Lit struct {
+	Value string
+}
+
+// SglQuoted is 'text', or a \ escaped character if Escaped
+type SglQuoted struct {
+	Value   string
+	Escaped bool
+}
+
+// DblQuoted is "parts", which are expanded but not split or globbed
+type DblQuoted struct {
+	Parts []WordPart
+}
+
+// ParamExp is $NAME or ${NAME...}.  Op is one of - = ? + % %% # ##, with a
+// leading : for the ones that take an empty value for an unset one, and
+// Word is its operand.  Length is ${#NAME}.
+type ParamExp struct {
+	Name   string
+	Length bool
+	Op     string
+	Word   *Word
+}
+
+// CmdSubst is $(list), or `list`
+type CmdSubst struct {
+	Body      *List
+	Backquote bool
+}
+
+// ArithExp is $((expression)); the expression is expanded like a double
+// quoted string before it is worked out
+type ArithExp struct {
+	Expr *Word
+}
+
+func (*Lit) wordPart()       {}
+func (*SglQuoted) wordPart() {}
+func (*DblQuoted) wordPart() {}
+func (*ParamExp) wordPart()  {}
+func (*CmdSubst) wordPart()  {}
+func (*ArithExp) wordPart()  {}
+
+// Dump writes node to w as an indented tree, a node to a line, for seeing
+// how a script was parsed
+func Dump(w io.Writer, node Node) {
+	d := dumper{w: w}
+	d.node(node)
+}
+
+type dumper struct {
+	w     io.Writer
+	depth int
+}
+
+func (d *dumper) line(format string, args ...interface{}) {
+	fmt.Fprintf(d.w, "%s%s\n", strings.Repeat("  ", d.depth), fmt.Sprintf(format, args...))
+}
+
+// nested dumps what's inside the node the line before
+func (d *dumper) nested(f func()) {
+	d.depth++
+	f()
+	d.depth--
+}
+
+func (d *dumper) list(label string, l *List) {
+	if l == nil {
+		return
+	}
+	d.line("%s", label)
+	d.nested(func() { d.node(l) })
+}
+
+func (d *dumper) node(node Node) {
+	switch n := node.(type) {
+	case *File:
+		d.line("File %q", n.Name)
+		d.nested(func() { d.node(n.Body) })
+	case *List:
+		for _, item := range n.Items {
+			d.node(item)
+		}
+	case *AndOr:
+		async := ""
+		if n.Async {
+			async = " &"
+		}
+		if len(n.Pipelines) == 1 {
+			if async != "" {
+				d.line("Async")
+				d.nested(func() { d.node(n.Pipelines[0]) })
+			} else {
+				d.node(n.Pipelines[0])
+			}
+			return
+		}
+		d.line("AndOr %s%s", strings.Join(n.Ops, " "), async)
+		d.nested(func() {
+			for _, p := range n.Pipelines {
+				d.node(p)
+			}
+		})
+	case *Pipeline:
+		if len(n.Commands) == 1 && !n.Bang {
+			d.node(n.Commands[0])
+			return
+		}
+		bang := ""
+		if n.Bang {
+			bang = " !"
+		}
+		d.line("Pipeline%s @%s", bang, n.Pos)
+		d.nested(func() {
+			for _, c := range n.Commands {
+				d.node(c)
+			}
+		})
+	case *SimpleCommand:
+		d.line("SimpleCommand @%s", n.Pos)
+		d.nested(func() { d.simple(n) })
+	case *Subshell:
+		d.line("Subshell @%s", n.Pos)
+		d.nested(func() { d.node(n.Body) })
+	case *BraceGroup:
+		d.line("BraceGroup @%s", n.Pos)
+		d.nested(func() { d.node(n.Body) })
+	case *IfClause:
+		d.line("If @%s", n.Pos)
+		d.nested(func() {
+			for i, clause := range n.Clauses {
+				label := "if"
+				if i > 0 {
+					label = "elif"
+				}
+				d.list(label, clause.Cond)
+				d.list("then", clause.Body)
+			}
+			d.list("else", n.Else)
+		})
+	case *WhileClause:
+		label := "While"
+		if n.Until {
+			label = "Until"
+		}
+		d.line("%s @%s", label, n.Pos)
+		d.nested(func() {
+			d.list("cond", n.Cond)
+			d.list("do", n.Body)
+		})
+	case *ForClause:
+		d.line("For %s @%s", n.Name, n.Pos)
+		d.nested(func() {
+			if n.In {
+				d.line("in")
+				d.nested(func() { d.words(n.Items) })
+			}
+			d.list("do", n.Body)
+		})
+	case *CaseClause:
+		d.line("Case %s @%s", wordString(n.Word), n.Pos)
+		d.nested(func() {
+			for _, item := range n.Items {
+				d.line("%s)", wordsString(item.Patterns))
+				d.nested(func() { d.node(item.Body) })
+			}
+		})
+	case *FuncDecl:
+		d.line("Func %s @%s", n.Name, n.Pos)
+		d.nested(func() { d.node(n.Body) })
+	case *Redirected:
+		d.line("Redirected @%s", n.Pos)
+		d.nested(func() {
+			d.node(n.Command)
+			d.redirects(n.Redirs)
+		})
+	}
+}
+
+func (d *dumper) redirects(redirs []*Redirect) {
+	for _, r := range redirs {
+		d.line("Redirect %d%s%s", r.N, r.Op, wordString(r.Word))
+		if r.Hdoc != nil {
+			d.nested(func() { d.line("Hdoc %s", strconv.Quote(wordString(r.Hdoc))) })
+		}
+	}
+}
+
+func (d *dumper) simple(n *SimpleCommand) {
+	for _, a := range n.Assigns {
+		d.line("Assign %s=%s", a.Name, wordString(a.Value))
+	}
+	d.words(n.Args)
+	d.redirects(n.Redirs)
+}
+
+func (d *dumper) words(words []*Word) {
+	for _, w := range words {
+		d.line("Word %s", wordString(w))
+	}
+}
+
+func wordsString(words []*Word) string {
+	s := make([]string, len(words))
+	for i, w := range words {
+		s[i] = wordString(w)
+	}
+	return strings.Join(s, " | ")
+}
+
+// wordString is the word's parts, bracketed by what they are
+func wordString(w *Word) string {
+	if w == nil {
+		return ""
+	}
+	b := strings.Builder{}
+	partsString(&b, w.Parts)
+	return b.String()
+}
+
+func partsString(b *strings.Builder, parts []WordPart) {
+	for _, part := range parts {
+		switch p := part.(type) {
+		case *Lit:
+			b.WriteString(p.Value)
+		case *SglQuoted:
+			if p.Escaped {
+				b.WriteString(`\` + p.Value)
+			} else {
+				b.WriteString(`'` + p.Value + `'`)
+			}
+		case *DblQuoted:
+			b.WriteString(`"`)
+			partsString(b, p.Parts)
+			b.WriteString(`"`)
+		case *ParamExp:
+			b.WriteString("${")
+			if p.Length {
+				b.WriteString("#")
+			}
+			b.WriteString(p.Name + p.Op + wordString(p.Word) + "}")
+		case *CmdSubst:
+			b.WriteString("$(...)")
+		case *ArithExp:
+			b.WriteString("$((" + wordString(p.Expr) + "))")
+		}
+	}
+}
@@ -0,0 +1,875 @@
+package sh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+// builtin runs a command inside the shell, which it can change, with args
+// as its arguments after its name, args[0]
+type builtin func(s *Shell, std *stdio, args []string) int
+
+// specialBuiltins are found before functions, keep the assignments before
+// them, and end the shell when they fail on bad usage
+var specialBuiltins map[string]builtin
+
+// builtins are found after functions and before applets and $PATH
+var builtins map[string]builtin
+
+func init() {
+	specialBuiltins = map[string]builtin{
+		":":        func(*Shell, *stdio, []string) int { return 0 },
+		".":        (*Shell).dot,
+		"break":    (*Shell).breakLoop,
+		"continue": (*Shell).breakLoop,
+		"eval":     (*Shell).eval,
+		"exec":     (*Shell).execBuiltin,
+		"exit":     (*Shell).exitBuiltin,
+		"export":   (*Shell).export,
+		"readonly": (*Shell).export,
+		"return":   (*Shell).returnBuiltin,
+		"set":      (*Shell).set,
+		"shift":    (*Shell).shift,
+		"trap":     (*Shell).trap,
+		"unset":    (*Shell).unset,
+	}
+	builtins = map[string]builtin{
+		"cd":      (*Shell).cd,
+		"command": (*Shell).commandBuiltin,
+		"echo":    echo,
+		"false":   func(*Shell, *stdio, []string) int { return 1 },
+		"getopts": (*Shell).getopts,
+		"kill":    (*Shell).kill,
+		"pwd":     (*Shell).pwd,
+		"read":    (*Shell).read,
+		"test":    test,
+		"[":       test,
+		"true":    func(*Shell, *stdio, []string) int { return 0 },
+		"umask":   (*Shell).umask,
+		"wait":    (*Shell).wait,
+	}
+}
+
+// reservedWords are the words the parser takes as part of a command, where
+// one starts
+var reservedWords = map[string]bool{
+	"!": true, "{": true, "}": true, "case": true, "do": true, "done": true, "elif": true, "else": true,
+	"esac": true, "fi": true, "for": true, "if": true, "in": true, "then": true, "until": true, "while": true,
+}
+
+// number is a builtin's numeric argument, or the status to fail with
+func (s *Shell) number(std *stdio, name, arg string) (int, bool) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 {
+		s.errorf(std, "%s: Illegal number: %s", name, arg)
+		s.fail(2)
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *Shell) breakLoop(std *stdio, args []string) int {
+	n := 1
+	if len(args) > 1 {
+		var ok bool
+		if n, ok = s.number(std, args[0], args[1]); !ok {
+			return 2
+		}
+		if n == 0 {
+			s.errorf(std, "%s: Illegal number: %s", args[0], args[1])
+			return s.fail(2)
+		}
+	}
+	if s.loops == 0 {
+		return 0
+	}
+	if n > s.loops {
+		n = s.loops
+	}
+	s.flow, s.flowDepth = flowBreak, n
+	if args[0] == "continue" {
+		s.flow = flowContinue
+	}
+	return 0
+}
+
+func (s *Shell) exitBuiltin(std *stdio, args []string) int {
+	status := s.status
+	if len(args) > 1 {
+		var ok bool
+		if status, ok = s.number(std, args[0], args[1]); !ok {
+			return 2
+		}
+	}
+	return s.exit(status & 0xff)
+}
+
+// returnBuiltin leaves the function, or the script outside of one
+func (s *Shell) returnBuiltin(std *stdio, args []string) int {
+	status := s.status
+	if len(args) > 1 {
+		var ok bool
+		if status, ok = s.number(std, args[0], args[1]); !ok {
+			return 2
+		}
+	}
+	if s.functions == 0 {
+		return s.exit(status)
+	}
+	s.status = status
+	s.flow = flowReturn
+	return status
+}
+
+// eval runs its arguments, joined with spaces, as a script
+func (s *Shell) eval(std *stdio, args []string) int {
+	s.status = 0
+	return s.source(std, "eval", strings.Join(args[1:], " "))
+}
+
+// source runs script in this shell, a command at a time; a syntax error in
+// it ends the shell
+func (s *Shell) source(std *stdio, name, script string) int {
+	p := newParser(script)
+	for s.flow == flowNone {
+		list, err := p.command()
+		if err != nil {
+			s.errorf(std, "%s: Syntax error: %s", name, err.(*SyntaxError).Msg)
+			return s.fail(2)
+		}
+		if list == nil {
+			break
+		}
+		s.list(std, list)
+	}
+	return s.status
+}
+
+// dot runs the file in this shell, from $PATH if its name has no /
+func (s *Shell) dot(std *stdio, args []string) int {
+	if len(args) < 2 {
+		return 0
+	}
+	name := args[1]
+	path := s.path(name)
+	if !strings.Contains(name, "/") {
+		dirs, _ := s.lookup("PATH")
+		for _, dir := range filepath.SplitList(dirs) {
+			if candidate := s.path(filepath.Join(dir, name)); isFile(candidate) {
+				path = candidate
+				break
+			}
+		}
+	}
+	script, err := os.ReadFile(path)
+	if err != nil {
+		s.errorf(std, ".: cannot open %s: No such file", name)
+		return s.fail(2)
+	}
+	saved := s.Params
+	if len(args) > 2 {
+		s.Params = args[2:]
+	}
+	s.status = 0
+	status := s.source(std, ".", string(script))
+	if len(args) > 2 {
+		s.Params = saved
+	}
+	return status
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// execBuiltin runs its arguments as a command, and then the shell is done
+func (s *Shell) execBuiltin(std *stdio, args []string) int {
+	if len(args) < 2 {
+		return 0
+	}
+	return s.exit(s.run(std, args[1:], s.environ(nil)))
+}
+
+// stdPath is where command -p looks for programs, whatever $PATH is
+const stdPath = "/usr/sbin:/usr/bin:/sbin:/bin"
+
+// commandBuiltin runs its arguments as a command that isn't a function, with
+// a special builtin failing like a regular one.  With -v or -V it says what
+// the name would run instead.
+func (s *Shell) commandBuiltin(std *stdio, args []string) int {
+	path, _ := s.lookup("PATH")
+	describe := byte(0)
+	args = args[1:]
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for i := 1; i < len(arg); i++ {
+			switch arg[i] {
+			case 'p':
+				path = stdPath
+			case 'v', 'V':
+				describe = arg[i]
+			default:
+				s.errorf(std, "command: Illegal option -%c", arg[i])
+				return 2
+			}
+		}
+	}
+	if len(args) == 0 {
+		return 0
+	}
+	if describe != 0 {
+		return s.describe(std, args[0], describe == 'V', path)
+	}
+	if special, ok := specialBuiltins[args[0]]; ok {
+		commanded := s.commanded
+		s.commanded = true
+		status := special(s, std, args)
+		s.commanded = commanded
+		return status
+	}
+	if builtin, ok := builtins[args[0]]; ok {
+		return builtin(s, std, args)
+	}
+	return s.runIn(std, args, s.environ(nil), path)
+}
+
+// describe writes what name runs, for command -v: the path of a program
+// and the name of anything else; verbose, for -V, says what it is
+func (s *Shell) describe(std *stdio, name string, verbose bool, path string) int {
+	what := ""
+	switch {
+	case reservedWords[name]:
+		what = "a shell keyword"
+	case specialBuiltins[name] != nil:
+		what = "a special shell builtin"
+	case s.funcs[name] != nil:
+		what = "a shell function"
+	case builtins[name] != nil:
+		what = "a shell builtin"
+	case !strings.Contains(name, "/") && lib.RegisteredFunctions().Contains(name):
+		what = "a slimbox applet"
+	}
+	found := name
+	if what == "" {
+		program, err := s.lookPath(name, path)
+		if err != nil {
+			if verbose {
+				fmt.Fprintf(std.out, "%s: not found\n", name)
+			}
+			return 127
+		}
+		if !strings.Contains(name, "/") {
+			found = program
+		}
+		what = found
+	}
+	if verbose {
+		fmt.Fprintf(std.out, "%s is %s\n", name, what)
+	} else {
+		fmt.Fprintln(std.out, found)
+	}
+	return 0
+}
+
+// export and readonly mark variables, setting them if they have a value;
+// with no names they list the ones they have marked
+func (s *Shell) export(std *stdio, args []string) int {
+	name := args[0]
+	names := args[1:]
+	if len(names) > 0 && names[0] == "-p" {
+		names = names[1:]
+	}
+	if len(names) == 0 {
+		for _, v := range s.sortedVars() {
+			if name == "export" && v.exported || name == "readonly" && v.readonly {
+				if v.set {
+					fmt.Fprintf(std.out, "%s %s=%s\n", name, v.name, shellQuote(v.value))
+				} else {
+					fmt.Fprintf(std.out, "%s %s\n", name, v.name)
+				}
+			}
+		}
+		return 0
+	}
+	for _, arg := range names {
+		varName, value, set := strings.Cut(arg, "=")
+		if !IsName(varName) {
+			s.errorf(std, "%s: %s: bad variable name", name, varName)
+			return s.fail(2)
+		}
+		if set {
+			if err := s.setVar(varName, value); err != nil {
+				s.errorf(std, "%s", err)
+				return s.fail(2)
+			}
+		}
+		v, ok := s.vars[varName]
+		if !ok {
+			v = &variable{}
+			s.vars[varName] = v
+		}
+		if name == "export" {
+			v.exported = true
+		} else {
+			v.readonly = true
+		}
+	}
+	return 0
+}
+
+type namedVariable struct {
+	name string
+	*variable
+}
+
+func (s *Shell) sortedVars() []namedVariable {
+	vars := make([]namedVariable, 0, len(s.vars))
+	for name, v := range s.vars {
+		vars = append(vars, namedVariable{name, v})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].name < vars[j].name })
+	return vars
+}
+
+// shellQuote single quotes value, the way set and export list variables
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func (s *Shell) unset(std *stdio, args []string) int {
+	functions := false
+	names := args[1:]
+	if len(names) > 0 && (names[0] == "-f" || names[0] == "-v") {
+		functions = names[0] == "-f"
+		names = names[1:]
+	}
+	for _, name := range names {
+		if functions {
+			delete(s.funcs, name)
+			continue
+		}
+		if v, ok := s.vars[name]; ok && v.readonly {
+			s.errorf(std, "unset: %s: is read only", name)
+			return s.fail(2)
+		}
+		delete(s.vars, name)
+	}
+	return 0
+}
+
+func (s *Shell) shift(std *stdio, args []string) int {
+	n := 1
+	if len(args) > 1 {
+		var ok bool
+		if n, ok = s.number(std, args[0], args[1]); !ok {
+			return 2
+		}
+	}
+	if n > len(s.Params) {
+		s.errorf(std, "shift: can't shift that many")
+		return s.fail(2)
+	}
+	s.Params = s.Params[n:]
+	return 0
+}
+
+// setOptions are the options set and sh take, by letter and by the name
+// for set -o
+var setOptions = []struct {
+	flag byte
+	name string
+	on   func(*options) *bool
+}{
+	{'e', "errexit", func(o *options) *bool { return &o.errexit }},
+	{'f', "noglob", func(o *options) *bool { return &o.noglob }},
+	{'n', "noexec", func(o *options) *bool { return &o.noexec }},
+	{'u', "nounset", func(o *options) *bool { return &o.nounset }},
+	{'x', "xtrace", func(o *options) *bool { return &o.xtrace }},
+}
+
+// set turns options on with - and off with +, and makes the rest of its
+// arguments the positional parameters; with none it lists the variables
+func (s *Shell) set(std *stdio, args []string) int {
+	args = args[1:]
+	if len(args) == 0 {
+		for _, v := range s.sortedVars() {
+			if v.set {
+				fmt.Fprintf(std.out, "%s=%s\n", v.name, shellQuote(v.value))
+			}
+		}
+		return 0
+	}
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" || arg == "-" {
+			args = args[1:]
+			s.Params = append([]string(nil), args...)
+			return 0
+		}
+		if len(arg) < 2 || arg[0] != '-' && arg[0] != '+' {
+			break
+		}
+		on := arg[0] == '-'
+		args = args[1:]
+		if arg[1:] == "o" {
+			if len(args) == 0 {
+				s.listOptions(std)
+				return 0
+			}
+			if !s.setOption(std, args[0], on) {
+				return s.fail(2)
+			}
+			args = args[1:]
+			continue
+		}
+		for i := 1; i < len(arg); i++ {
+			if !s.setFlag(std, arg[i], on) {
+				return s.fail(2)
+			}
+		}
+	}
+	if len(args) > 0 {
+		s.Params = append([]string(nil), args...)
+	}
+	return 0
+}
+
+func (s *Shell) setFlag(std *stdio, flag byte, on bool) bool {
+	for _, o := range setOptions {
+		if o.flag == flag {
+			*o.on(&s.opts) = on
+			return true
+		}
+	}
+	s.errorf(std, "set: Illegal option -%c", flag)
+	return false
+}
+
+func (s *Shell) setOption(std *stdio, name string, on bool) bool {
+	for _, o := range setOptions {
+		if o.name == name {
+			*o.on(&s.opts) = on
+			return true
+		}
+	}
+	s.errorf(std, "set: Illegal option -o %s", name)
+	return false
+}
+
+func (s *Shell) listOptions(std *stdio) {
+	fmt.Fprintln(std.out, "Current option settings")
+	for _, o := range setOptions {
+		state := "off"
+		if *o.on(&s.opts) {
+			state = "on"
+		}
+		fmt.Fprintf(std.out, "%-16s%s\n", o.name, state)
+	}
+}
+
+// cd changes the shell's directory, to $HOME if there's no argument and to
+// $OLDPWD for -
+func (s *Shell) cd(std *stdio, args []string) int {
+	dir, _ := s.lookup("HOME")
+	if len(args) > 1 {
+		dir = args[1]
+	}
+	print := false
+	if dir == "-" {
+		dir, _ = s.lookup("OLDPWD")
+		print = true
+	}
+	path := filepath.Clean(s.path(dir))
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		s.errorf(std, "cd: can't cd to %s", dir)
+		return 2
+	}
+	s.setVar("OLDPWD", s.Dir)
+	s.Dir = path
+	s.setVar("PWD", path)
+	if print {
+		fmt.Fprintln(std.out, path)
+	}
+	return 0
+}
+
+func (s *Shell) pwd(std *stdio, args []string) int {
+	fmt.Fprintln(std.out, s.Dir)
+	return 0
+}
+
+// echo writes its arguments with spaces between, and a newline unless the
+// first one is -n.  Like dash's, it takes the XSI escapes: \c stops it.
+func echo(s *Shell, std *stdio, args []string) int {
+	args = args[1:]
+	newline := true
+	if len(args) > 0 && args[0] == "-n" {
+		newline = false
+		args = args[1:]
+	}
+	w := bufio.NewWriter(std.out)
+	for i, arg := range args {
+		if i > 0 {
+			w.WriteByte(' ')
+		}
+		if !unescapeEcho(w, arg) {
+			newline = false
+			break
+		}
+	}
+	if newline {
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		// like dash, which says the same whatever went wrong, and nothing
+		// when the reader has gone away
+		if !errors.Is(err, syscall.EPIPE) {
+			s.errorf(std, "echo: echo: I/O error")
+		}
+		return 1
+	}
+	return 0
+}
+
+// unescapeEcho writes arg with echo's escapes worked out, and false if it
+// had a \c
+func unescapeEcho(w *bufio.Writer, arg string) bool {
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		if c != '\\' || i+1 == len(arg) {
+			w.WriteByte(c)
+			continue
+		}
+		i++
+		switch arg[i] {
+		case 'a':
+			w.WriteByte('\a')
+		case 'b':
+			w.WriteByte('\b')
+		case 'c':
+			return false
+		case 'f':
+			w.WriteByte('\f')
+		case 'n':
+			w.WriteByte('\n')
+		case 'r':
+			w.WriteByte('\r')
+		case 't':
+			w.WriteByte('\t')
+		case 'v':
+			w.WriteByte('\v')
+		case '\\':
+			w.WriteByte('\\')
+		case '0':
+			n := byte(0)
+			for j := 0; j < 3 && i+1 < len(arg) && arg[i+1] >= '0' && arg[i+1] <= '7'; j++ {
+				i++
+				n = n*8 + arg[i] - '0'
+			}
+			w.WriteByte(n)
+		default:
+			w.WriteByte('\\')
+			w.WriteByte(arg[i])
+		}
+	}
+	return true
+}
+
+// read reads a line into the variables named, split on $IFS, with the
+// rest of the line in the last one.  Without -r, a \ escapes the next
+// character and joins lines.  It reads a byte at a time so nothing after
+// the line is taken from whatever comes next.
+func (s *Shell) read(std *stdio, args []string) int {
+	raw := false
+	names := args[1:]
+	if len(names) > 0 && names[0] == "-r" {
+		raw = true
+		names = names[1:]
+	}
+	if len(names) == 0 {
+		s.errorf(std, "read: arg count")
+		return 2
+	}
+
+	// escaped marks the characters a \ kept from being split on
+	var line []byte
+	var escaped []bool
+	status := 0
+	buf := make([]byte, 1)
+	for {
+		n, err := std.in.Read(buf)
+		if n == 0 {
+			if err == nil {
+				continue
+			}
+			if err != io.EOF {
+				s.errorf(std, "read: %s", err)
+			}
+			status = 1
+			break
+		}
+		c := buf[0]
+		if c == '\n' {
+			break
+		}
+		if c == '\\' && !raw {
+			if n, _ := std.in.Read(buf); n == 0 {
+				break
+			}
+			if buf[0] == '\n' {
+				continue
+			}
+			line, escaped = append(line, buf[0]), append(escaped, true)
+			continue
+		}
+		line, escaped = append(line, c), append(escaped, false)
+	}
+
+	ifs := s.ifs()
+	isIFS := func(i int) bool { return !escaped[i] && strings.IndexByte(ifs, line[i]) >= 0 }
+	isWhite := func(i int) bool { return isIFS(i) && strings.IndexByte(" \t\n", line[i]) >= 0 }
+	i := 0
+	for i < len(line) && isWhite(i) {
+		i++
+	}
+	for n, name := range names {
+		start := i
+		if n == len(names)-1 {
+			end := len(line)
+			for end > i && isWhite(end-1) {
+				end--
+			}
+			i = end
+		} else {
+			for i < len(line) && !isIFS(i) {
+				i++
+			}
+		}
+		value := string(line[start:i])
+		// the field ends at one IFS character, and the white space round it
+		for i < len(line) && isWhite(i) {
+			i++
+		}
+		if i < len(line) && isIFS(i) && n < len(names)-1 {
+			i++
+			for i < len(line) && isWhite(i) {
+				i++
+			}
+		}
+		if err := s.setVar(name, value); err != nil {
+			s.errorf(std, "%s", err)
+			return 2
+		}
+	}
+	return status
+}
+
+// getopts puts the next option in args, or else the positional parameters,
+// in the variable named, with its argument in $OPTARG, and moves $OPTIND
+// past it; it fails at the end of the options.  optstring has the options,
+// with a : after those that take an argument, and starts with a : to get a
+// bad option through the variables rather than on standard error.
+func (s *Shell) getopts(std *stdio, args []string) int {
+	if len(args) < 3 {
+		s.errorf(std, "getopts: Usage: getopts optstring var [arg...]")
+		return 2
+	}
+	optstring, name, params := args[1], args[2], s.Params
+	if len(args) > 3 {
+		params = args[3:]
+	}
+	if !IsName(name) {
+		s.errorf(std, "getopts: %s: bad variable name", name)
+		return 2
+	}
+	silent := strings.HasPrefix(optstring, ":")
+	index, _ := s.lookup("OPTIND")
+	optind, err := strconv.Atoi(index)
+	if err != nil || optind < 1 {
+		optind = 1
+	}
+	if index != s.optInd || optind < 2 || optind-2 >= len(params) || s.optOff >= len(params[optind-2]) {
+		s.optOff = 0
+	}
+
+	// like dash, $OPTIND is past an argument as soon as getopts starts on it
+	var arg string
+	if s.optOff > 0 {
+		arg = params[optind-2]
+	} else {
+		if optind > len(params) || len(params[optind-1]) < 2 || params[optind-1][0] != '-' {
+			return s.setOpt(std, name, "?", optind, nil, 1)
+		}
+		arg = params[optind-1]
+		optind++
+		if arg == "--" {
+			return s.setOpt(std, name, "?", optind, nil, 1)
+		}
+		s.optOff = 1
+	}
+	c := arg[s.optOff]
+	s.optOff++
+	opt, optarg := string(c), new(string)
+	switch at := strings.IndexByte(optstring, c); {
+	case c == ':' || at < 0:
+		opt = "?"
+		if silent {
+			*optarg = string(c)
+		} else {
+			fmt.Fprintf(std.err, "Illegal option -%c\n", c)
+			optarg = nil
+		}
+	case at+1 < len(optstring) && optstring[at+1] == ':':
+		switch {
+		case s.optOff < len(arg):
+			*optarg = arg[s.optOff:]
+			s.optOff = len(arg)
+		case optind <= len(params):
+			*optarg = params[optind-1]
+			optind++
+		case silent:
+			opt, *optarg = ":", string(c)
+		default:
+			fmt.Fprintf(std.err, "No arg for -%c option\n", c)
+			opt, optarg = "?", nil
+		}
+	}
+	if s.optOff >= len(arg) {
+		s.optOff = 0
+	}
+	return s.setOpt(std, name, opt, optind, optarg, 0)
+}
+
+// setOpt sets getopts' variables and returns status: name to opt, $OPTIND
+// to optind, and $OPTARG to optarg, unsetting it if it's nil, unless status
+// is the end of the options, which leaves it be
+func (s *Shell) setOpt(std *stdio, name, opt string, optind int, optarg *string, status int) int {
+	s.optInd = strconv.Itoa(optind)
+	err := s.setVar("OPTIND", s.optInd)
+	if err == nil {
+		err = s.setVar(name, opt)
+	}
+	if err == nil && status == 0 {
+		if optarg != nil {
+			err = s.setVar("OPTARG", *optarg)
+		} else if v, ok := s.vars["OPTARG"]; ok && v.readonly {
+			err = fmt.Errorf("OPTARG: is read only")
+		} else {
+			delete(s.vars, "OPTARG")
+		}
+	}
+	if err != nil {
+		s.errorf(std, "getopts: %s", err)
+		return 2
+	}
+	return status
+}
+
+// umask sets the mask of permissions new files don't get, in octal or as
+// symbolic modes like chmod's, or writes it, symbolically with -S
+func (s *Shell) umask(std *stdio, args []string) int {
+	symbolic := false
+	args = args[1:]
+	if len(args) > 0 && args[0] == "-S" {
+		symbolic = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		if symbolic {
+			fmt.Fprintln(std.out, symbolicMode(^s.mask&0777))
+		} else {
+			fmt.Fprintf(std.out, "%04o\n", s.mask)
+		}
+		return 0
+	}
+	arg := args[0]
+	if arg[0] >= '0' && arg[0] <= '9' {
+		n, err := strconv.ParseUint(arg, 8, 32)
+		if err != nil {
+			s.errorf(std, "umask: Illegal number: %s", arg)
+			return 2
+		}
+		s.mask = int(n & 0777)
+		return 0
+	}
+	perms, ok := applyMode(arg, ^s.mask&0777)
+	if !ok {
+		s.errorf(std, "umask: Illegal mode: %s", arg)
+		return 2
+	}
+	s.mask = ^perms & 0777
+	return 0
+}
+
+// applyMode is perms changed by the symbolic mode, clauses like u+w or
+// go=rx separated by commas, or false if mode isn't one
+func applyMode(mode string, perms int) (int, bool) {
+	for _, clause := range strings.Split(mode, ",") {
+		who, i := 0, 0
+		for ; i < len(clause) && strings.IndexByte("ugoa", clause[i]) >= 0; i++ {
+			who |= map[byte]int{'u': 0700, 'g': 0070, 'o': 0007, 'a': 0777}[clause[i]]
+		}
+		if who == 0 {
+			who = 0777
+		}
+		if i == len(clause) {
+			return 0, false
+		}
+		for i < len(clause) {
+			op := clause[i]
+			if op != '+' && op != '-' && op != '=' {
+				return 0, false
+			}
+			bits := 0
+			for i++; i < len(clause) && strings.IndexByte("rwx", clause[i]) >= 0; i++ {
+				bits |= map[byte]int{'r': 0444, 'w': 0222, 'x': 0111}[clause[i]]
+			}
+			bits &= who
+			switch op {
+			case '+':
+				perms |= bits
+			case '-':
+				perms &^= bits
+			case '=':
+				perms = perms&^who | bits
+			}
+		}
+	}
+	return perms, true
+}
+
+// symbolicMode is perms the way umask -S writes them, like u=rwx,g=rx,o=rx
+func symbolicMode(perms int) string {
+	clauses := make([]string, 0, 3)
+	for i, who := range "ugo" {
+		clause := []byte{byte(who), '='}
+		for j, p := range "rwx" {
+			if perms&(0400>>(3*i+j)) != 0 {
+				clause = append(clause, byte(p))
+			}
+		}
+		clauses = append(clauses, string(clause))
+	}
+	return strings.Join(clauses, ",")
+}
+
+// wait for the commands run with &
+func (s *Shell) wait(std *stdio, args []string) int {
+	s.jobs.Wait()
+	return 0
+}
@@ -0,0 +1,780 @@
+package sh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+// Shell is a shell's state: its variables, functions, parameters, options
+// and working directory.  Commands run in-process where they can: builtins,
+// functions, and the slimbox applets compiled in, through the registry.
+// Anything else is looked up on $PATH and run as a process of its own.
+type Shell struct {
+	// Name is $0, which error messages start with
+	Name string
+	// Params are the positional parameters, $1 on
+	Params []string
+	// Dir is the working directory
+	Dir string
+
+	vars  map[string]*variable
+	funcs map[string]*FuncDecl
+	opts  options
+
+	// status is $?
+	status int
+	// substStatus is the status of the last command substitution, which is
+	// the status of a command that is only assignments
+	substStatus int
+	// line is where the command running started, for error messages
+	line int
+	// flow is a break, continue, return or exit on its way out
+	flow flow
+	// flowDepth is how many more loops a break or continue leaves
+	flowDepth int
+	// conditions is how deep in conditions the shell is, where set -e
+	// doesn't apply
+	conditions int
+	// functions is how deep in function calls the shell is
+	functions int
+	// loops is how many loops the running command is in
+	loops int
+	// commanded is command running a special builtin, which then doesn't
+	// end the shell when it fails
+	commanded bool
+	// optOff is how far getopts is into the argument before $OPTIND, for
+	// options grouped like -ab, as long as $OPTIND is still optInd
+	optOff int
+	optInd string
+
+	// traps are the actions trap set, by signal number, with 0 for EXIT;
+	// an empty action ignores the signal
+	traps map[int]string
+	// signals are where each signal with an action comes in, by number
+	signals map[int]chan os.Signal
+	// mask is the umask: the permissions files the shell creates, and the
+	// programs it starts, don't get.  The process's is shared with every
+	// other shell in it, so it's only set while a program starts.
+	mask int
+
+	// jobs are the commands run with &, for wait
+	jobs *sync.WaitGroup
+}
+
+type variable struct {
+	value              string
+	set                bool
+	exported, readonly bool
+}
+
+type options struct {
+	errexit, nounset, xtrace, noglob, noexec bool
+}
+
+type flow int
+
+const (
+	flowNone flow = iota
+	flowBreak
+	flowContinue
+	flowReturn
+	flowExit
+)
+
+// stdio is where a command reads and writes: descriptors 0, 1 and 2, and
+// fds, any others that are open
+type stdio struct {
+	in       io.Reader
+	out, err io.Writer
+	fds      map[int]interface{}
+}
+
+// New is a shell in ctx's directory, with its environment as exported
+// variables
+func New(ctx *lib.Context) *Shell {
+	s := &Shell{
+		Name:  "sh",
+		Dir:   ctx.Dir,
+		vars:  make(map[string]*variable),
+		funcs: make(map[string]*FuncDecl),
+		jobs:  &sync.WaitGroup{},
+		mask:  processUmask(),
+	}
+	if ctx.Umask != nil {
+		s.mask = *ctx.Umask
+	}
+	if s.Dir == "" {
+		s.Dir, _ = os.Getwd()
+	}
+	for _, kv := range ctx.Env {
+		if eq := strings.IndexByte(kv, '='); eq > 0 && IsName(kv[:eq]) {
+			s.vars[kv[:eq]] = &variable{value: kv[eq+1:], set: true, exported: true}
+		}
+	}
+	if abs, err := filepath.Abs(s.Dir); err == nil {
+		s.Dir = abs
+	}
+	s.setVar("PWD", s.Dir)
+	s.setVar("OPTIND", "1")
+	return s
+}
+
+// Run parses and runs script, a command at a time, with ctx's streams, and
+// returns the exit status.  A syntax error stops it there, with status 2.
+func (s *Shell) Run(ctx *lib.Context, script string) int {
+	return s.runParser(ctx, newParser(script))
+}
+
+// RunReader is Run for a script read from r as it runs, a complete command
+// at a time, so commands run as they're typed or piped in
+func (s *Shell) RunReader(ctx *lib.Context, r io.Reader) int {
+	p := newReaderParser(r)
+	status := s.runParser(ctx, p)
+	if p.err != nil {
+		return lib.Report(ctx.Stderr, lib.OperandError("sh", "-", p.err))
+	}
+	return status
+}
+
+// runParser runs the commands p parses until the end or an exit
+func (s *Shell) runParser(ctx *lib.Context, p *parser) int {
+	std := (&stdio{in: ctx.Stdin, out: ctx.Stdout, err: ctx.Stderr, fds: ctx.Fds}).copy()
+	if std.in == nil {
+		std.in = strings.NewReader("")
+	}
+	defer s.jobs.Wait()
+	for s.flow != flowExit {
+		list, err := p.command()
+		if err != nil {
+			fmt.Fprintf(std.err, "%s: %s\n", s.Name, err)
+			s.status = 2
+			break
+		}
+		if list == nil {
+			break
+		}
+		if !s.opts.noexec {
+			s.list(std, list)
+		}
+	}
+	s.exited(std)
+	return s.status
+}
+
+// errorf reports a failure the way dash does, with the script's name and
+// the line
+func (s *Shell) errorf(std *stdio, format string, args ...interface{}) {
+	fmt.Fprintf(std.err, "%s: %d: %s\n", s.Name, s.line, fmt.Sprintf(format, args...))
+}
+
+// exit the shell with status
+func (s *Shell) exit(status int) int {
+	s.status = status
+	s.flow = flowExit
+	return status
+}
+
+// fail is a special builtin failing, which ends the shell with status
+// unless command ran it
+func (s *Shell) fail(status int) int {
+	if s.commanded {
+		s.status = status
+		return status
+	}
+	return s.exit(status)
+}
+
+// subshell is a copy of the shell, which nothing it does changes
+func (s *Shell) subshell() *Shell {
+	sub := *s
+	sub.Params = append([]string(nil), s.Params...)
+	sub.vars = make(map[string]*variable, len(s.vars))
+	for name, v := range s.vars {
+		copied := *v
+		sub.vars[name] = &copied
+	}
+	sub.funcs = make(map[string]*FuncDecl, len(s.funcs))
+	for name, f := range s.funcs {
+		sub.funcs[name] = f
+	}
+	sub.jobs = &sync.WaitGroup{}
+	sub.flow = flowNone
+	// only ignoring a signal carries on into a subshell
+	sub.traps = make(map[int]string, len(s.traps))
+	for n, action := range s.traps {
+		if action == "" {
+			sub.traps[n] = action
+			if n != 0 {
+				ignore(syscall.Signal(n))
+			}
+		}
+	}
+	sub.signals = nil
+	return &sub
+}
+
+// Variables
+
+func (s *Shell) lookup(name string) (string, bool) {
+	v, ok := s.vars[name]
+	if !ok || !v.set {
+		return "", false
+	}
+	return v.value, true
+}
+
+func (s *Shell) setVar(name, value string) error {
+	v, ok := s.vars[name]
+	if !ok {
+		v = &variable{}
+		s.vars[name] = v
+	}
+	if v.readonly {
+		return fmt.Errorf("%s: is read only", name)
+	}
+	v.value, v.set = value, true
+	return nil
+}
+
+// environ is the exported variables, with extra on top, for a command
+func (s *Shell) environ(extra []string) []string {
+	env := make([]string, 0, len(s.vars)+len(extra))
+	for name, v := range s.vars {
+		if v.exported && v.set {
+			env = append(env, name+"="+v.value)
+		}
+	}
+	return append(env, extra...)
+}
+
+// Running commands
+
+func (s *Shell) list(std *stdio, l *List) int {
+	for _, item := range l.Items {
+		if s.flow != flowNone {
+			break
+		}
+		if item.Async {
+			s.async(std, item)
+		} else {
+			s.andOr(std, item)
+		}
+		s.trapped(std)
+	}
+	return s.status
+}
+
+// async runs item in a subshell, without waiting for it.  Its input is
+// /dev/null, as there is no job control to give it the terminal.
+func (s *Shell) async(std *stdio, item *AndOr) {
+	sub := s.subshell()
+	std = std.copy()
+	std.in = strings.NewReader("")
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		sub.andOr(std, &AndOr{Pipelines: item.Pipelines, Ops: item.Ops})
+		sub.exited(std)
+		sub.jobs.Wait()
+	}()
+	s.status = 0
+}
+
+func (s *Shell) andOr(std *stdio, a *AndOr) int {
+	last := len(a.Pipelines) - 1
+	for i, p := range a.Pipelines {
+		if i > 0 {
+			op := a.Ops[i-1]
+			if op == "&&" && s.status != 0 || op == "||" && s.status == 0 {
+				continue
+			}
+		}
+		if i < last {
+			s.conditions++
+			s.pipeline(std, p)
+			s.conditions--
+		} else {
+			s.pipeline(std, p)
+		}
+		if s.flow != flowNone {
+			break
+		}
+	}
+	return s.status
+}
+
+func (s *Shell) pipeline(std *stdio, p *Pipeline) int {
+	if p.Bang {
+		s.conditions++
+	}
+	var status int
+	if len(p.Commands) == 1 {
+		status = s.command(std, p.Commands[0])
+	} else {
+		status = s.pipe(std, p.Commands)
+	}
+	if p.Bang {
+		s.conditions--
+		if status == 0 {
+			status = 1
+		} else {
+			status = 0
+		}
+	}
+	s.status = status
+	if status != 0 && s.opts.errexit && s.conditions == 0 && !p.Bang && s.flow == flowNone {
+		s.exit(status)
+	}
+	return status
+}
+
+// pipe runs each command in a subshell of its own, all at once, with each
+// one's output going through a pipe to the next one's input.  The status
+// is the last one's.
+func (s *Shell) pipe(std *stdio, commands []Command) int {
+	statuses := make([]int, len(commands))
+	wg := sync.WaitGroup{}
+	in := std.in
+	for i, c := range commands {
+		stage := std.copy()
+		stage.in = in
+		var w *os.File
+		if i < len(commands)-1 {
+			r, pw, err := os.Pipe()
+			if err != nil {
+				s.errorf(std, "Cannot create pipe: %s", err)
+				return 2
+			}
+			stage.out, w = pw, pw
+			in = r
+		}
+		sub := s.subshell()
+		wg.Add(1)
+		go func(i int, c Command, stage *stdio, w *os.File) {
+			defer wg.Done()
+			statuses[i] = sub.command(stage, c)
+			sub.exited(stage)
+			if sub.flow == flowExit {
+				statuses[i] = sub.status
+			}
+			sub.jobs.Wait()
+			if w != nil {
+				w.Close()
+			}
+			if r, ok := stage.in.(*os.File); ok && i > 0 {
+				// so anything still writing to it gets EPIPE
+				r.Close()
+			}
+		}(i, c, stage, w)
+	}
+	wg.Wait()
+	return statuses[len(statuses)-1]
+}
+
+func (s *Shell) command(std *stdio, c Command) int {
+	switch c := c.(type) {
+	case *SimpleCommand:
+		return s.simple(std, c)
+	case *Subshell:
+		// a copy, so exec in it doesn't redirect the shell outside
+		sub := s.subshell()
+		sub.list(std.copy(), c.Body)
+		sub.exited(std)
+		sub.jobs.Wait()
+		s.status = sub.status
+	case *BraceGroup:
+		s.list(std, c.Body)
+	case *IfClause:
+		return s.ifClause(std, c)
+	case *WhileClause:
+		return s.whileClause(std, c)
+	case *ForClause:
+		return s.forClause(std, c)
+	case *CaseClause:
+		return s.caseClause(std, c)
+	case *FuncDecl:
+		s.funcs[c.Name] = c
+		s.status = 0
+	case *Redirected:
+		s.line = c.Pos.Line
+		redirected, done, err := s.redirect(std, c.Redirs)
+		defer done()
+		if err != nil {
+			return s.redirectFailed(redirected, err)
+		}
+		return s.command(redirected, c.Command)
+	}
+	return s.status
+}
+
+// condition runs a list whose status is tested, where set -e doesn't apply
+func (s *Shell) condition(std *stdio, l *List) bool {
+	s.conditions++
+	defer func() { s.conditions-- }()
+	return s.list(std, l) == 0
+}
+
+func (s *Shell) ifClause(std *stdio, c *IfClause) int {
+	for _, clause := range c.Clauses {
+		ok := s.condition(std, clause.Cond)
+		if s.flow != flowNone {
+			return s.status
+		}
+		if ok {
+			return s.list(std, clause.Body)
+		}
+	}
+	s.status = 0
+	if c.Else != nil {
+		return s.list(std, c.Else)
+	}
+	return s.status
+}
+
+// loopDone is whether a loop stops here, after its body has run, taking
+// any break or continue aimed at it
+func (s *Shell) loopDone() bool {
+	switch s.flow {
+	case flowBreak, flowContinue:
+		if s.flowDepth > 1 {
+			s.flowDepth--
+			return true
+		}
+		brk := s.flow == flowBreak
+		s.flow = flowNone
+		return brk
+	case flowNone:
+		return false
+	}
+	return true
+}
+
+func (s *Shell) whileClause(std *stdio, c *WhileClause) int {
+	s.loops++
+	defer func() { s.loops-- }()
+	status := 0
+	for {
+		ok := s.condition(std, c.Cond)
+		if s.loopDone() || ok == c.Until {
+			break
+		}
+		status = s.list(std, c.Body)
+		if s.loopDone() {
+			break
+		}
+	}
+	if s.flow == flowNone {
+		s.status = status
+	}
+	return s.status
+}
+
+func (s *Shell) forClause(std *stdio, c *ForClause) int {
+	items := s.Params
+	if c.In {
+		var err error
+		if items, err = s.fields(std, c.Items); err != nil {
+			return s.expansionFailed(std, err)
+		}
+	}
+	s.loops++
+	defer func() { s.loops-- }()
+	s.status = 0
+	for _, item := range items {
+		if err := s.setVar(c.Name, item); err != nil {
+			s.errorf(std, "%s", err)
+			return s.exit(2)
+		}
+		s.list(std, c.Body)
+		if s.loopDone() {
+			break
+		}
+	}
+	return s.status
+}
+
+func (s *Shell) caseClause(std *stdio, c *CaseClause) int {
+	word, err := s.expandString(std, c.Word)
+	if err != nil {
+		return s.expansionFailed(std, err)
+	}
+	for _, item := range c.Items {
+		for _, pattern := range item.Patterns {
+			p, err := s.expandPattern(std, pattern)
+			if err != nil {
+				return s.expansionFailed(std, err)
+			}
+			if match(p, word) {
+				s.status = 0
+				return s.list(std, item.Body)
+			}
+		}
+	}
+	s.status = 0
+	return 0
+}
+
+// expansionFailed reports err, which ends a shell that isn't interactive
+func (s *Shell) expansionFailed(std *stdio, err error) int {
+	s.errorf(std, "%s", err)
+	return s.exit(2)
+}
+
+func (s *Shell) simple(std *stdio, c *SimpleCommand) int {
+	s.line = c.Pos.Line
+	s.substStatus = 0
+	args, err := s.fields(std, c.Args)
+	if err != nil {
+		return s.expansionFailed(std, err)
+	}
+	special := len(args) > 0 && specialBuiltins[args[0]] != nil
+	if len(args) == 1 && args[0] == "exec" {
+		// exec with only redirections makes them for the rest of the shell
+		if _, err := s.redirectIn(std, c.Redirs); err != nil {
+			s.redirectFailed(std, err)
+			return s.exit(2)
+		}
+	} else {
+		redirected, done, err := s.redirect(std, c.Redirs)
+		defer done()
+		if err != nil {
+			s.redirectFailed(redirected, err)
+			if special {
+				return s.exit(2)
+			}
+			return s.status
+		}
+		std = redirected
+	}
+	if len(args) == 0 {
+		// each assignment sees the ones before it
+		for _, a := range c.Assigns {
+			value, err := s.expandString(std, a.Value)
+			if err != nil {
+				return s.expansionFailed(std, err)
+			}
+			if s.opts.xtrace {
+				s.trace(std, []string{a.Name + "=" + value})
+			}
+			if err := s.setVar(a.Name, value); err != nil {
+				s.errorf(std, "%s", err)
+				return s.exit(2)
+			}
+		}
+		s.status = s.substStatus
+		return s.status
+	}
+	assigns := make([]string, len(c.Assigns))
+	for i, a := range c.Assigns {
+		value, err := s.expandString(std, a.Value)
+		if err != nil {
+			return s.expansionFailed(std, err)
+		}
+		assigns[i] = a.Name + "=" + value
+	}
+	if s.opts.xtrace {
+		s.trace(std, append(append([]string(nil), assigns...), args...))
+	}
+
+	name := args[0]
+	if special, ok := specialBuiltins[name]; ok {
+		// assignments before special builtins stay
+		for _, a := range assigns {
+			eq := strings.IndexByte(a, '=')
+			if err := s.setVar(a[:eq], a[eq+1:]); err != nil {
+				s.errorf(std, "%s", err)
+				return s.exit(2)
+			}
+		}
+		s.status = special(s, std, args)
+		return s.status
+	}
+	if f, ok := s.funcs[name]; ok {
+		restore := s.assignFor(assigns)
+		defer restore()
+		s.status = s.call(std, f, args[1:])
+		return s.status
+	}
+	if builtin, ok := builtins[name]; ok {
+		s.status = builtin(s, std, args)
+		return s.status
+	}
+	s.status = s.run(std, args, s.environ(assigns))
+	return s.status
+}
+
+// assignFor makes assigns, the assignments before a function call, last as
+// long as the call, and returns what undoes them
+func (s *Shell) assignFor(assigns []string) func() {
+	saved := make(map[string]*variable, len(assigns))
+	for _, a := range assigns {
+		eq := strings.IndexByte(a, '=')
+		name := a[:eq]
+		if _, done := saved[name]; !done {
+			saved[name] = s.vars[name]
+		}
+		s.vars[name] = &variable{value: a[eq+1:], set: true, exported: true}
+	}
+	return func() {
+		for name, v := range saved {
+			if v == nil {
+				delete(s.vars, name)
+			} else {
+				s.vars[name] = v
+			}
+		}
+	}
+}
+
+// call the function f with args as its parameters
+func (s *Shell) call(std *stdio, f *FuncDecl, args []string) int {
+	saved := s.Params
+	s.Params = args
+	s.functions++
+	s.command(std, f.Body)
+	s.functions--
+	s.Params = saved
+	if s.flow == flowReturn {
+		s.flow = flowNone
+	}
+	return s.status
+}
+
+// trace writes args for set -x.  Like dash, it doesn't quote them.
+func (s *Shell) trace(std *stdio, args []string) {
+	fmt.Fprintf(std.err, "+ %s\n", strings.Join(args, " "))
+}
+
+// run args as a slimbox applet, in-process, or else as a program on $PATH
+func (s *Shell) run(std *stdio, args []string, env []string) int {
+	path, _ := s.lookup("PATH")
+	return s.runIn(std, args, env, path)
+}
+
+// runIn is run, looking for programs on path instead of $PATH
+func (s *Shell) runIn(std *stdio, args []string, env []string, path string) int {
+	name := args[0]
+	if !strings.Contains(name, "/") && lib.RegisteredFunctions().Contains(name) {
+		umask := s.mask
+		ctx := &lib.Context{Stdin: std.in, Stdout: std.out, Stderr: std.err, Dir: s.Dir, Env: env, Fds: std.fds, Umask: &umask}
+		return lib.RunContext(ctx, name, args[1:])
+	}
+	program, err := s.lookPath(name, path)
+	if err != nil {
+		s.errorf(std, "%s: not found", name)
+		return 127
+	}
+	cmd := &exec.Cmd{Path: program, Args: args, Env: env, Dir: s.Dir}
+	fds, err := std.pass(cmd)
+	if err == nil {
+		err = s.start(cmd)
+		fds.started()
+		if err == nil {
+			err = cmd.Wait()
+		}
+		fds.wait()
+	}
+	return exitStatus(s, std, name, err)
+}
+
+// umaskMu is held while the process's mask isn't what it was, so no other
+// shell reads or starts a program with it
+var umaskMu sync.Mutex
+
+// processUmask is the process's mask, which there's no reading without
+// setting
+func processUmask() int {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return mask
+}
+
+// start cmd with the shell's mask, which it inherits
+func (s *Shell) start(cmd *exec.Cmd) error {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	saved := syscall.Umask(s.mask)
+	defer syscall.Umask(saved)
+	return cmd.Start()
+}
+
+// exitStatus is what err from running a program makes $?
+func exitStatus(s *Shell, std *stdio, name string, err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			// dash says what killed it, unless it was interrupted or its
+			// reader went away, which are what was meant to happen
+			if sig := ws.Signal(); sig != syscall.SIGINT && sig != syscall.SIGPIPE {
+				msg := sig.String()
+				fmt.Fprintln(std.err, strings.ToUpper(msg[:1])+msg[1:])
+			}
+			return 128 + int(ws.Signal())
+		}
+		return exitErr.ExitCode()
+	case errors.Is(err, os.ErrPermission), errors.Is(err, syscall.ENOEXEC):
+		s.errorf(std, "%s: Permission denied", name)
+		return 126
+	}
+	s.errorf(std, "%s", lib.OperandError(name, "", err).Error())
+	return 126
+}
+
+// lookPath finds name on path, which is a list like $PATH; a name with a /
+// in it is a path already, from the working directory
+func (s *Shell) lookPath(name, path string) (string, error) {
+	if strings.Contains(name, "/") {
+		path := s.path(name)
+		if _, err := os.Stat(path); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := s.path(filepath.Join(dir, name))
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// path resolves name against the shell's working directory
+func (s *Shell) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.Dir, name)
+}
+
+// substitute runs body in a subshell for $(...), and is its output without
+// the trailing newlines
+func (s *Shell) substitute(std *stdio, body *List) string {
+	out := &bytes.Buffer{}
+	sub := s.subshell()
+	std = std.copy()
+	std.out = out
+	sub.list(std, body)
+	sub.exited(std)
+	sub.jobs.Wait()
+	s.substStatus = sub.status
+	return strings.TrimRight(out.String(), "\n")
+}
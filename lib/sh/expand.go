@@ -0,0 +1,670 @@
+package sh
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A word is expanded in two steps: its parts become pieces of text, which
+// remember whether they were quoted, and then the pieces are split into
+// fields on $IFS and globbed, as far as they weren't.
+
+type piece struct {
+	text   string
+	quoted bool
+	// split is for the result of an unquoted expansion, which is split on $IFS
+	split bool
+	// field ends the field before it, for "$@"
+	field bool
+}
+
+// expandError is an expansion that can't be done, like ${x?} with x unset,
+// which ends a shell that isn't interactive
+type expandError struct {
+	msg string
+}
+
+func (e *expandError) Error() string { return e.msg }
+
+func expandErrorf(format string, args ...interface{}) error {
+	return &expandError{fmt.Sprintf(format, args...)}
+}
+
+// fields expands words into the arguments of a command
+func (s *Shell) fields(std *stdio, words []*Word) ([]string, error) {
+	var fields []string
+	for _, w := range words {
+		pieces, err := s.pieces(std, w.Parts, false, true)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, s.split(pieces)...)
+	}
+	return fields, nil
+}
+
+// expandString expands w without splitting or globbing it, for
+// assignments, case words and the like
+func (s *Shell) expandString(std *stdio, w *Word) (string, error) {
+	if w == nil {
+		return "", nil
+	}
+	pieces, err := s.pieces(std, w.Parts, false, true)
+	return joinPieces(pieces), err
+}
+
+// expandPattern expands w into a pattern for match, with what was quoted in
+// it escaped
+func (s *Shell) expandPattern(std *stdio, w *Word) (string, error) {
+	if w == nil {
+		return "", nil
+	}
+	pieces, err := s.pieces(std, w.Parts, false, false)
+	if err != nil {
+		return "", err
+	}
+	b := strings.Builder{}
+	for _, p := range pieces {
+		if p.field {
+			b.WriteByte(' ')
+		}
+		if p.quoted {
+			b.WriteString(escapePattern(p.text))
+		} else {
+			b.WriteString(p.text)
+		}
+	}
+	return b.String(), nil
+}
+
+func escapePattern(text string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(text); i++ {
+		if strings.IndexByte(`*?[]\`, text[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
+
+// pieces expands parts, which are inside double quotes if quoted; tilde is
+// whether a ~ at the start is a home directory
+func (s *Shell) pieces(std *stdio, parts []WordPart, quoted, tilde bool) ([]piece, error) {
+	var pieces []piece
+	for i, part := range parts {
+		switch p := part.(type) {
+		case *Lit:
+			text := p.Value
+			if i == 0 && tilde && !quoted && strings.HasPrefix(text, "~") {
+				if home, rest, ok := s.tilde(text); ok {
+					pieces = append(pieces, piece{text: home, quoted: true})
+					text = rest
+				}
+			}
+			pieces = append(pieces, piece{text: text, quoted: quoted})
+		case *SglQuoted:
+			pieces = append(pieces, piece{text: p.Value, quoted: true})
+		case *DblQuoted:
+			inner, err := s.pieces(std, p.Parts, true, false)
+			if err != nil {
+				return nil, err
+			}
+			if !onlyAt(p.Parts) {
+				// "" is a field even though it's empty
+				pieces = append(pieces, piece{quoted: true})
+			}
+			pieces = append(pieces, inner...)
+		case *ParamExp:
+			if (p.Name == "@" || p.Name == "*" && !quoted) && p.Op == "" && !p.Length {
+				for j, param := range s.Params {
+					pieces = append(pieces, piece{text: param, quoted: quoted, split: !quoted, field: j > 0})
+				}
+				continue
+			}
+			expanded, err := s.param(std, p, quoted)
+			if err != nil {
+				return nil, err
+			}
+			pieces = append(pieces, expanded...)
+		case *CmdSubst:
+			out := s.substitute(std, p.Body)
+			pieces = append(pieces, piece{text: out, quoted: quoted, split: !quoted})
+		case *ArithExp:
+			expr, err := s.expandString(std, p.Expr)
+			if err != nil {
+				return nil, err
+			}
+			n, err := s.arithmetic(expr)
+			if err != nil {
+				return nil, err
+			}
+			pieces = append(pieces, piece{text: strconv.FormatInt(n, 10), quoted: quoted, split: !quoted})
+		}
+	}
+	return pieces, nil
+}
+
+// onlyAt is whether parts is just "$@", which is no field at all when
+// there are no parameters
+func onlyAt(parts []WordPart) bool {
+	if len(parts) != 1 {
+		return false
+	}
+	p, ok := parts[0].(*ParamExp)
+	return ok && p.Name == "@" && p.Op == "" && !p.Length
+}
+
+// tilde expands the ~ or ~user at the start of text, up to the first /
+func (s *Shell) tilde(text string) (home, rest string, ok bool) {
+	name := text[1:]
+	if slash := strings.IndexByte(name, '/'); slash >= 0 {
+		name, rest = name[:slash], name[slash:]
+	}
+	if name == "" {
+		home, ok = s.lookup("HOME")
+		return home, rest, ok
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", "", false
+	}
+	return u.HomeDir, rest, true
+}
+
+// param expands a parameter other than a bare $@.  What ${x-word} and the
+// like expand to is word's own pieces, as quoted as they were in it.
+func (s *Shell) param(std *stdio, p *ParamExp, quoted bool) ([]piece, error) {
+	value, set := s.paramValue(p.Name)
+	expanded := func(value string) []piece {
+		return []piece{{text: value, quoted: quoted, split: !quoted}}
+	}
+	if p.Length {
+		if !set && s.opts.nounset {
+			return nil, expandErrorf("%s: parameter not set", p.Name)
+		}
+		return expanded(strconv.Itoa(utf8.RuneCountInString(value))), nil
+	}
+	colon := strings.HasPrefix(p.Op, ":")
+	op := strings.TrimPrefix(p.Op, ":")
+	empty := !set || colon && value == ""
+	word := func() ([]piece, error) {
+		pieces, err := s.pieces(std, p.Word.partsOrNil(), quoted, true)
+		for i := range pieces {
+			pieces[i].split = pieces[i].split || !pieces[i].quoted
+		}
+		return pieces, err
+	}
+	wordString := func() (string, error) {
+		pieces, err := word()
+		return joinPieces(pieces), err
+	}
+	switch op {
+	case "":
+		if !set && s.opts.nounset && p.Name != "@" && p.Name != "*" {
+			return nil, expandErrorf("%s: parameter not set", p.Name)
+		}
+		return expanded(value), nil
+	case "-":
+		if empty {
+			return word()
+		}
+		return expanded(value), nil
+	case "=":
+		if !empty {
+			return expanded(value), nil
+		}
+		if !IsName(p.Name) {
+			return nil, expandErrorf("%s: bad variable name", p.Name)
+		}
+		w, err := wordString()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.setVar(p.Name, w); err != nil {
+			return nil, &expandError{err.Error()}
+		}
+		return expanded(w), nil
+	case "?":
+		if !empty {
+			return expanded(value), nil
+		}
+		msg, err := wordString()
+		if err != nil {
+			return nil, err
+		}
+		if msg == "" {
+			msg = "parameter not set"
+			if colon {
+				msg = "parameter not set or null"
+			}
+		}
+		return nil, expandErrorf("%s: %s", p.Name, msg)
+	case "+":
+		if empty {
+			return nil, nil
+		}
+		return word()
+	}
+
+	if !set && s.opts.nounset {
+		return nil, expandErrorf("%s: parameter not set", p.Name)
+	}
+	pattern, err := s.expandPattern(std, p.Word)
+	if err != nil {
+		return nil, err
+	}
+	return expanded(trim(value, pattern, op)), nil
+}
+
+// joinPieces is pieces as one string, with a space between "$@"'s fields
+func joinPieces(pieces []piece) string {
+	b := strings.Builder{}
+	for _, p := range pieces {
+		if p.field {
+			b.WriteByte(' ')
+		}
+		b.WriteString(p.text)
+	}
+	return b.String()
+}
+
+func (w *Word) partsOrNil() []WordPart {
+	if w == nil {
+		return nil
+	}
+	return w.Parts
+}
+
+// trim the shortest or longest match of pattern off the start (# and ##) or
+// the end (% and %%) of value
+func trim(value, pattern, op string) string {
+	switch op {
+	case "#":
+		for i := 0; i <= len(value); i++ {
+			if match(pattern, value[:i]) {
+				return value[i:]
+			}
+		}
+	case "##":
+		for i := len(value); i >= 0; i-- {
+			if match(pattern, value[:i]) {
+				return value[i:]
+			}
+		}
+	case "%":
+		for i := len(value); i >= 0; i-- {
+			if match(pattern, value[i:]) {
+				return value[:i]
+			}
+		}
+	case "%%":
+		for i := 0; i <= len(value); i++ {
+			if match(pattern, value[i:]) {
+				return value[:i]
+			}
+		}
+	}
+	return value
+}
+
+// paramValue is the value of the variable or special parameter name, and
+// whether it is set
+func (s *Shell) paramValue(name string) (string, bool) {
+	switch name {
+	case "@", "*":
+		sep := " "
+		if name == "*" {
+			if ifs, ok := s.lookup("IFS"); ok {
+				sep = ""
+				if ifs != "" {
+					sep = ifs[:1]
+				}
+			}
+		}
+		return strings.Join(s.Params, sep), len(s.Params) > 0
+	case "#":
+		return strconv.Itoa(len(s.Params)), true
+	case "?":
+		return strconv.Itoa(s.status), true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "!":
+		return "", false
+	case "-":
+		return s.flags(), true
+	case "0":
+		return s.Name, true
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 1 || n > len(s.Params) {
+			return "", false
+		}
+		return s.Params[n-1], true
+	}
+	return s.lookup(name)
+}
+
+// flags is $-, the options that are on
+func (s *Shell) flags() string {
+	b := strings.Builder{}
+	for _, o := range []struct {
+		flag byte
+		on   bool
+	}{{'e', s.opts.errexit}, {'f', s.opts.noglob}, {'n', s.opts.noexec}, {'u', s.opts.nounset}, {'x', s.opts.xtrace}} {
+		if o.on {
+			b.WriteByte(o.flag)
+		}
+	}
+	return b.String()
+}
+
+// ifs is $IFS, which is space, tab and newline unless it is set
+func (s *Shell) ifs() string {
+	if ifs, ok := s.lookup("IFS"); ok {
+		return ifs
+	}
+	return " \t\n"
+}
+
+// field is a field being put together from pieces
+type field struct {
+	text, pattern strings.Builder
+	// glob is an unquoted glob character in it
+	glob bool
+	// quoted is anything quoted in it, so it's a field even if it's empty
+	quoted bool
+}
+
+func (f *field) empty() bool { return f.text.Len() == 0 && !f.quoted }
+
+func (f *field) add(text string, quoted bool) {
+	f.text.WriteString(text)
+	if quoted {
+		f.quoted = true
+		f.pattern.WriteString(escapePattern(text))
+		return
+	}
+	f.pattern.WriteString(text)
+	if strings.ContainsAny(text, "*?[") {
+		f.glob = true
+	}
+}
+
+// split pieces into fields at $IFS in the ones to split, and glob them
+func (s *Shell) split(pieces []piece) []string {
+	var fields []string
+	f := &field{}
+	end := func() {
+		if f.glob && !s.opts.noglob {
+			if matches := s.glob(f.pattern.String()); len(matches) > 0 {
+				fields = append(fields, matches...)
+				f = &field{}
+				return
+			}
+		}
+		fields = append(fields, f.text.String())
+		f = &field{}
+	}
+
+	ifs := s.ifs()
+	for _, p := range pieces {
+		if p.field {
+			end()
+		}
+		if !p.split || ifs == "" {
+			f.add(p.text, p.quoted)
+			continue
+		}
+		// white is whether the field was just ended by IFS white space,
+		// which one other IFS character next to it doesn't end again
+		white := false
+		start := 0
+		for i := 0; i < len(p.text); i++ {
+			c := p.text[i]
+			if strings.IndexByte(ifs, c) < 0 {
+				white = false
+				continue
+			}
+			f.add(p.text[start:i], false)
+			start = i + 1
+			if c == ' ' || c == '\t' || c == '\n' {
+				if !f.empty() {
+					end()
+					white = true
+				}
+				continue
+			}
+			if !f.empty() || !white {
+				end()
+			}
+			white = false
+		}
+		f.add(p.text[start:], false)
+	}
+	if !f.empty() {
+		end()
+	}
+	return fields
+}
+
+// match is whether name matches the shell pattern, where * and ? match any
+// character, / and a leading . too, and [...] is a bracket expression
+func match(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if match(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if name == "" {
+				return false
+			}
+			_, size := utf8.DecodeRuneInString(name)
+			pattern, name = pattern[1:], name[size:]
+			continue
+		case '[':
+			if name == "" {
+				return false
+			}
+			r, size := utf8.DecodeRuneInString(name)
+			if ok, rest, valid := matchBracket(pattern, r); valid {
+				if !ok {
+					return false
+				}
+				pattern, name = rest, name[size:]
+				continue
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+		}
+		if name == "" || pattern[0] != name[0] {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return name == ""
+}
+
+// matchBracket matches r against the bracket expression at the start of
+// pattern, and returns the pattern after it; valid is false if the [ isn't
+// closed, and so is just a [
+func matchBracket(pattern string, r rune) (ok bool, rest string, valid bool) {
+	i := 1
+	negate := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negate = true
+		i++
+	}
+	for first := true; i < len(pattern); first = false {
+		c := pattern[i]
+		if c == ']' && !first {
+			return ok != negate, pattern[i+1:], true
+		}
+		if c == '[' && i+1 < len(pattern) && pattern[i+1] == ':' {
+			if end := strings.Index(pattern[i+2:], ":]"); end >= 0 {
+				if inClass(pattern[i+2:i+2+end], r) {
+					ok = true
+				}
+				i += end + 4
+				continue
+			}
+		}
+		if c == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		lo, size := utf8.DecodeRuneInString(pattern[i:])
+		i += size
+		hi := lo
+		if i+1 < len(pattern) && pattern[i] == '-' && pattern[i+1] != ']' {
+			i++
+			if pattern[i] == '\\' && i+1 < len(pattern) {
+				i++
+			}
+			hi, size = utf8.DecodeRuneInString(pattern[i:])
+			i += size
+		}
+		if lo <= r && r <= hi {
+			ok = true
+		}
+	}
+	return false, pattern, false
+}
+
+// inClass is whether r is in the character class [:name:]
+func inClass(name string, r rune) bool {
+	switch name {
+	case "alpha":
+		return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+	case "digit":
+		return r >= '0' && r <= '9'
+	case "alnum":
+		return inClass("alpha", r) || inClass("digit", r)
+	case "upper":
+		return r >= 'A' && r <= 'Z'
+	case "lower":
+		return r >= 'a' && r <= 'z'
+	case "space":
+		return r == ' ' || r >= '\t' && r <= '\r'
+	case "blank":
+		return r == ' ' || r == '\t'
+	case "punct":
+		return r > ' ' && r < 0x7f && !inClass("alnum", r)
+	case "print":
+		return r >= ' ' && r < 0x7f
+	case "graph":
+		return r > ' ' && r < 0x7f
+	case "cntrl":
+		return r < ' ' || r == 0x7f
+	case "xdigit":
+		return inClass("digit", r) || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+	}
+	return false
+}
+
+// hasGlob is whether pattern has an unescaped glob character
+func hasGlob(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+func unescape(pattern string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String()
+}
+
+// glob is the paths pattern matches, sorted; a * or ? doesn't match a / or
+// a leading . in a name, which only a literal . does
+func (s *Shell) glob(pattern string) []string {
+	paths := []string{""}
+	if strings.HasPrefix(pattern, "/") {
+		paths = []string{"/"}
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		var next []string
+		for _, dir := range paths {
+			if !hasGlob(segment) {
+				next = append(next, join(dir, unescape(segment)))
+				continue
+			}
+			entries, err := os.ReadDir(s.path(join(dir, ".")))
+			if err != nil {
+				continue
+			}
+			// a pattern starting with a . matches . and .., which ReadDir
+			// leaves out
+			names := []string{".", ".."}
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			for _, name := range names {
+				if strings.HasPrefix(name, ".") && !strings.HasPrefix(unescape(segment), ".") {
+					continue
+				}
+				if !match(segment, name) {
+					continue
+				}
+				path := join(dir, name)
+				if !last {
+					if info, err := os.Stat(s.path(path)); err != nil || !info.IsDir() {
+						continue
+					}
+				}
+				next = append(next, path)
+			}
+		}
+		paths = next
+	}
+	matches := paths[:0]
+	for _, path := range paths {
+		if _, err := os.Lstat(s.path(path)); err == nil {
+			matches = append(matches, path)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// join name onto dir as it was written in the pattern
+func join(dir, name string) string {
+	switch {
+	case dir == "":
+		return name
+	case strings.HasSuffix(dir, "/"):
+		return dir + name
+	}
+	return dir + "/" + name
+}
@@ -0,0 +1,1072 @@
+package sh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The shell's tokens are read as the parser asks for them, since what a
+// character means depends on where the parser is: a reserved word is only
+// one where a command starts, and $( reads a whole command list.
+
+type tokKind int
+
+const (
+	tEOF tokKind = iota
+	tWord
+	tNewline
+	tSemi   // ;
+	tAmp    // &
+	tPipe   // |
+	tAndIf  // &&
+	tOrIf   // ||
+	tDSemi  // ;;
+	tLParen // (
+	tRParen // )
+	tRedir  // < > >> <> >| <& >& << <<-, with the descriptor in fd
+)
+
+type token struct {
+	kind tokKind
+	pos  Pos
+	// text as it was written, for error messages
+	text string
+	word *Word
+	fd   int
+}
+
+// SyntaxError is a script that doesn't parse
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *SyntaxError) Error() string { return fmt.Sprintf("%d: Syntax error: %s", e.Pos.Line, e.Msg) }
+
+type parser struct {
+	src       string
+	off       int
+	line, col int
+	// in is where the rest of the script comes from, a line at a time as
+	// the parser gets to it, when it isn't all in src to start with
+	in *bufio.Reader
+	// err is why in ended, if it wasn't the end of the script
+	err error
+
+	tok token
+	// peeked is the token after tok, if it has been read
+	peeked *token
+	// started is the next command's first token having been read, which
+	// command leaves until it's asked for that command, so one line runs
+	// before the next is read
+	started bool
+	// hdocs are here-documents whose bodies start after the next newline
+	hdocs []*Redirect
+}
+
+func newParser(src string) *parser {
+	return &parser{src: src, line: 1, col: 1}
+}
+
+// newReaderParser parses the script in r, reading no more of it than it
+// has to for each command
+func newReaderParser(r io.Reader) *parser {
+	return &parser{in: bufio.NewReader(r), line: 1, col: 1}
+}
+
+// Parse parses a whole script; name is what it's called in error messages
+func Parse(name, src string) (f *File, err error) {
+	p := newParser(src)
+	defer p.recover(&err)
+	p.next()
+	body := p.list()
+	if p.tok.kind != tEOF {
+		p.unexpected()
+	}
+	return &File{Name: name, Body: body}, nil
+}
+
+// command parses the next complete command of the script, so each one can
+// run before the rest is parsed, the way shells do; nil is the end.
+func (p *parser) command() (list *List, err error) {
+	defer p.recover(&err)
+	if !p.started {
+		// nothing before the read position is needed again
+		p.src, p.off = p.src[p.off:], 0
+		p.started = true
+		p.next()
+	}
+	if p.tok.kind == tEOF {
+		return nil, nil
+	}
+	p.linebreak()
+	if p.tok.kind == tEOF {
+		return nil, nil
+	}
+	list = &List{Pos: p.tok.pos}
+	for {
+		item := p.andOr()
+		list.Items = append(list.Items, item)
+		switch p.tok.kind {
+		case tAmp:
+			item.Async = true
+			p.next()
+		case tSemi:
+			p.next()
+		case tNewline, tEOF:
+		default:
+			p.unexpected()
+		}
+		if p.tok.kind == tNewline || p.tok.kind == tEOF {
+			p.started = false
+			return list, nil
+		}
+		if !p.startsCommand() {
+			p.unexpected()
+		}
+	}
+}
+
+func (p *parser) recover(err *error) {
+	if r := recover(); r != nil {
+		syntax, ok := r.(*SyntaxError)
+		if !ok {
+			panic(r)
+		}
+		*err = syntax
+	}
+}
+
+func (p *parser) fail(pos Pos, format string, args ...interface{}) {
+	panic(&SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *parser) unexpected() {
+	switch p.tok.kind {
+	case tEOF:
+		p.fail(p.tok.pos, "end of file unexpected")
+	case tNewline:
+		p.fail(p.tok.pos, "newline unexpected")
+	case tRedir:
+		p.fail(p.tok.pos, "redirection unexpected")
+	}
+	p.fail(p.tok.pos, "%q unexpected", p.tok.text)
+}
+
+// Reading characters
+
+// fill reads lines from in until there are n characters from the read
+// position on, and is whether there are
+func (p *parser) fill(n int) bool {
+	for p.off+n > len(p.src) && p.in != nil {
+		line, err := p.in.ReadString('\n')
+		p.src += line
+		if err != nil {
+			if err != io.EOF {
+				p.err = err
+			}
+			p.in = nil
+		}
+	}
+	return p.off+n <= len(p.src)
+}
+
+// ch is the character at the read position, after any line continuations,
+// or 0 at the end
+func (p *parser) ch() byte {
+	for p.fill(1) && p.src[p.off] == '\\' && p.fill(2) && p.src[p.off+1] == '\n' {
+		p.off += 2
+		p.line++
+		p.col = 1
+	}
+	if !p.fill(1) {
+		return 0
+	}
+	return p.src[p.off]
+}
+
+// advance past the character at the read position
+func (p *parser) advance() {
+	if p.src[p.off] == '\n' {
+		p.line++
+		p.col = 1
+	} else {
+		p.col++
+	}
+	p.off++
+}
+
+func (p *parser) pos() Pos { return Pos{p.line, p.col} }
+
+// Tokens
+
+// next moves on to the next token
+func (p *parser) next() {
+	if p.peeked != nil {
+		p.tok, p.peeked = *p.peeked, nil
+		return
+	}
+	p.tok = p.lex()
+}
+
+// peek is the token after the current one
+func (p *parser) peek() token {
+	if p.peeked == nil {
+		t := p.lex()
+		p.peeked = &t
+	}
+	return *p.peeked
+}
+
+// operators are the characters that make up operators by themselves, and
+// the operators two of them make
+var operators = map[byte]struct {
+	single, double tokKind
+}{
+	'&': {tAmp, tAndIf}, '|': {tPipe, tOrIf}, ';': {tSemi, tDSemi},
+	'(': {tLParen, tEOF}, ')': {tRParen, tEOF}, '\n': {tNewline, tEOF},
+}
+
+func (p *parser) lex() token {
+	for {
+		switch p.ch() {
+		case ' ', '\t':
+			p.advance()
+			continue
+		case '#':
+			for c := p.ch(); c != 0 && c != '\n'; c = p.ch() {
+				p.advance()
+			}
+			continue
+		}
+		break
+	}
+	pos := p.pos()
+	c := p.ch()
+	switch c {
+	case 0:
+		p.hdocBodies()
+		return token{kind: tEOF, pos: pos}
+	case '<', '>':
+		return p.redirOp(pos, -1)
+	}
+	if op, ok := operators[c]; ok {
+		p.advance()
+		if op.double != tEOF && p.ch() == c {
+			p.advance()
+			return token{kind: op.double, pos: pos, text: string([]byte{c, c})}
+		}
+		if c == '\n' {
+			p.hdocBodies()
+		}
+		return token{kind: op.single, pos: pos, text: string(c)}
+	}
+	start := p.off
+	word := p.word(pos, isMeta)
+	// digits right before a redirection are the descriptor it's for
+	if lit, ok := word.Lit(); ok && (p.ch() == '<' || p.ch() == '>') {
+		if n, err := strconv.Atoi(lit); err == nil && lit[0] != '+' && lit[0] != '-' {
+			return p.redirOp(pos, n)
+		}
+	}
+	return token{kind: tWord, pos: pos, text: p.src[start:p.off], word: word}
+}
+
+// redirOp reads a redirection operator for descriptor n, or the default
+// one for the operator if n is -1
+func (p *parser) redirOp(pos Pos, n int) token {
+	c := p.ch()
+	op := []byte{c}
+	p.advance()
+	switch next := p.ch(); {
+	case c == '<' && next == '<':
+		op = append(op, next)
+		p.advance()
+		if p.ch() == '-' {
+			op = append(op, '-')
+			p.advance()
+		}
+	case next == '&', c == '<' && next == '>', c == '>' && (next == '>' || next == '|'):
+		op = append(op, next)
+		p.advance()
+	}
+	if n < 0 {
+		n = 1
+		if c == '<' {
+			n = 0
+		}
+	}
+	return token{kind: tRedir, pos: pos, text: string(op), fd: n}
+}
+
+// hdocBodies reads the bodies of the here-documents on the line that just
+// ended: each is the lines up to its delimiter, or the end of the script
+func (p *parser) hdocBodies() {
+	for _, r := range p.hdocs {
+		delim, quoted := hdocDelimiter(r.Word)
+		pos := p.pos()
+		body := strings.Builder{}
+		for p.fill(1) {
+			line := p.src[p.off:]
+			if end := strings.IndexByte(line, '\n'); end >= 0 {
+				line = line[:end+1]
+			}
+			for i := 0; i < len(line); i++ {
+				p.advance()
+			}
+			if r.Op == "<<-" {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if strings.TrimSuffix(line, "\n") == delim {
+				break
+			}
+			body.WriteString(line)
+		}
+		if quoted {
+			r.Hdoc = &Word{Pos: pos, Parts: []WordPart{&SglQuoted{Value: body.String()}}}
+			continue
+		}
+		// a backslash only escapes $, ` and itself, and " is just a "
+		sub := &parser{src: body.String(), line: pos.Line, col: pos.Col}
+		r.Hdoc = &Word{Pos: pos, Parts: []WordPart{&DblQuoted{Parts: sub.quotedParts(pos, 0, "$`\\")}}}
+	}
+	p.hdocs = nil
+}
+
+// hdocDelimiter is the delimiter word with its quotes removed, and whether
+// there were any, which leaves the body unexpanded
+func hdocDelimiter(word *Word) (string, bool) {
+	delim := strings.Builder{}
+	quoted := false
+	var add func(parts []WordPart)
+	add = func(parts []WordPart) {
+		for _, part := range parts {
+			switch part := part.(type) {
+			case *Lit:
+				delim.WriteString(part.Value)
+			case *SglQuoted:
+				delim.WriteString(part.Value)
+				quoted = true
+			case *DblQuoted:
+				quoted = true
+				add(part.Parts)
+			case *ParamExp:
+				delim.WriteString("$" + part.Name)
+			}
+		}
+	}
+	add(word.Parts)
+	return delim.String(), quoted
+}
+
+// isMeta is a character that ends an unquoted word
+func isMeta(c byte) bool {
+	switch c {
+	case 0, ' ', '\t', '\n', ';', '&', '|', '(', ')', '<', '>':
+		return true
+	}
+	return false
+}
+
+// Words
+
+// word reads the parts of a word up to an unquoted character that stop
+// says ends it
+func (p *parser) word(pos Pos, stop func(byte) bool) *Word {
+	w := &Word{Pos: pos}
+	lit := strings.Builder{}
+	flush := func() {
+		if lit.Len() > 0 {
+			w.Parts = append(w.Parts, &Lit{Value: lit.String()})
+			lit.Reset()
+		}
+	}
+	for c := p.ch(); !stop(c); c = p.ch() {
+		switch c {
+		case '\\':
+			p.advance()
+			flush()
+			if !p.fill(1) {
+				w.Parts = append(w.Parts, &SglQuoted{Value: `\`, Escaped: true})
+				continue
+			}
+			w.Parts = append(w.Parts, &SglQuoted{Value: p.src[p.off : p.off+1], Escaped: true})
+			p.advance()
+		case '\'':
+			flush()
+			w.Parts = append(w.Parts, p.single())
+		case '"':
+			flush()
+			w.Parts = append(w.Parts, p.double())
+		case '$', '`':
+			if part := p.dollar(); part != nil {
+				flush()
+				w.Parts = append(w.Parts, part)
+			} else {
+				lit.WriteByte('$')
+			}
+		default:
+			lit.WriteByte(c)
+			p.advance()
+		}
+	}
+	flush()
+	return w
+}
+
+// single reads a single quoted string, where nothing is special
+func (p *parser) single() WordPart {
+	pos := p.pos()
+	p.advance()
+	end := strings.IndexByte(p.src[p.off:], '\'')
+	for end < 0 && p.fill(len(p.src)-p.off+1) {
+		end = strings.IndexByte(p.src[p.off:], '\'')
+	}
+	if end < 0 {
+		p.fail(pos, "Unterminated quoted string")
+	}
+	value := p.src[p.off : p.off+end]
+	for i := 0; i <= end; i++ {
+		p.advance()
+	}
+	return &SglQuoted{Value: value}
+}
+
+// double reads a "" string, where only $, ` and \ are special
+func (p *parser) double() WordPart {
+	pos := p.pos()
+	p.advance()
+	parts := p.quotedParts(pos, '"', "$`\"\\")
+	p.advance()
+	return &DblQuoted{Parts: parts}
+}
+
+// quotedParts reads up to end, or to the end of the script if end is 0, as
+// in double quotes: a backslash only escapes the characters in escapes
+func (p *parser) quotedParts(pos Pos, end byte, escapes string) []WordPart {
+	var parts []WordPart
+	lit := strings.Builder{}
+	flush := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, &Lit{Value: lit.String()})
+			lit.Reset()
+		}
+	}
+	for {
+		c := p.ch()
+		switch {
+		case c == end:
+			flush()
+			return parts
+		case c == 0:
+			p.fail(pos, "Unterminated quoted string")
+		case c == '\\':
+			p.advance()
+			if n := p.ch(); n != 0 && strings.IndexByte(escapes, n) >= 0 {
+				flush()
+				parts = append(parts, &SglQuoted{Value: string(n), Escaped: true})
+				p.advance()
+			} else {
+				lit.WriteByte('\\')
+			}
+		case c == '$' || c == '`':
+			if part := p.dollar(); part != nil {
+				flush()
+				parts = append(parts, part)
+			} else {
+				lit.WriteByte('$')
+			}
+		default:
+			lit.WriteByte(c)
+			p.advance()
+		}
+	}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || c >= '0' && c <= '9'
+}
+
+// IsName is s being a valid variable name
+func IsName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// special parameters, which are one character
+const specialParams = "@*#?-$!0123456789"
+
+// dollar reads an expansion starting with $ or `, or returns nil, past the
+// $, if it's just a dollar sign
+func (p *parser) dollar() WordPart {
+	pos := p.pos()
+	if p.ch() == '`' {
+		return p.backquote()
+	}
+	p.advance()
+	c := p.ch()
+	switch {
+	case c == '(':
+		p.advance()
+		if p.ch() == '(' {
+			if part := p.arithmetic(); part != nil {
+				return part
+			}
+		}
+		return p.substitution(pos)
+	case c == '{':
+		p.advance()
+		return p.braced(pos)
+	case isNameStart(c):
+		start := p.off
+		for isNameChar(p.ch()) {
+			p.advance()
+		}
+		return &ParamExp{Name: p.src[start:p.off]}
+	case c != 0 && strings.IndexByte(specialParams, c) >= 0:
+		p.advance()
+		return &ParamExp{Name: string(c)}
+	}
+	return nil
+}
+
+// substitution reads $(list), after the $(
+func (p *parser) substitution(pos Pos) WordPart {
+	sub := &parser{src: p.src, off: p.off, line: p.line, col: p.col, in: p.in}
+	sub.next()
+	body := sub.list()
+	if sub.tok.kind != tRParen {
+		if sub.tok.kind == tEOF {
+			p.fail(sub.tok.pos, "end of file unexpected (expecting \")\")")
+		}
+		sub.unexpected()
+	}
+	p.src, p.off, p.line, p.col, p.in, p.err = sub.src, sub.off, sub.line, sub.col, sub.in, sub.err
+	return &CmdSubst{Body: body}
+}
+
+// backquote reads `list`, which is the script between the backquotes
+// without the backslashes before \, ` and $
+func (p *parser) backquote() WordPart {
+	pos := p.pos()
+	p.advance()
+	body := strings.Builder{}
+	for {
+		c := p.ch()
+		switch c {
+		case 0:
+			p.fail(pos, "EOF in backquote substitution")
+		case '`':
+			p.advance()
+			sub := newParser(body.String())
+			sub.line = pos.Line
+			sub.next()
+			list := sub.list()
+			if sub.tok.kind != tEOF {
+				sub.unexpected()
+			}
+			return &CmdSubst{Body: list, Backquote: true}
+		case '\\':
+			p.advance()
+			if n := p.ch(); n == '\\' || n == '`' || n == '$' {
+				c = n
+			} else {
+				body.WriteByte('\\')
+				continue
+			}
+		}
+		body.WriteByte(c)
+		p.advance()
+	}
+}
+
+// arithmetic reads $((expression)), after the $(, or returns nil without
+// reading anything if the parentheses don't close with )) and so it must
+// be $( (subshell) ) instead
+func (p *parser) arithmetic() WordPart {
+	start := p.off + 1
+	depth := 0
+	for i := start; p.fill(i - p.off + 1); i++ {
+		switch p.src[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if !p.fill(i-p.off+2) || p.src[i+1] != ')' {
+				return nil
+			}
+			expr := newParser(p.src[start:i])
+			expr.line, expr.col = p.line, p.col+1
+			parts := expr.quotedParts(p.pos(), 0, "$`\\")
+			for p.off < i+2 {
+				p.advance()
+			}
+			return &ArithExp{Expr: &Word{Pos: Pos{expr.line, expr.col}, Parts: parts}}
+		}
+	}
+	p.fail(p.pos(), "Missing '))'")
+	return nil
+}
+
+// braced reads ${...}, after the ${
+func (p *parser) braced(pos Pos) WordPart {
+	exp := &ParamExp{}
+	if p.ch() == '#' {
+		// ${#} is the number of parameters; ${#NAME} is a length
+		if p.fill(2) && p.src[p.off+1] != '}' {
+			exp.Length = true
+			p.advance()
+		}
+	}
+	c := p.ch()
+	start := p.off
+	switch {
+	case isNameStart(c):
+		for isNameChar(p.ch()) {
+			p.advance()
+		}
+	case c >= '0' && c <= '9':
+		for c := p.ch(); c >= '0' && c <= '9'; c = p.ch() {
+			p.advance()
+		}
+	case c != 0 && strings.IndexByte(specialParams, c) >= 0:
+		p.advance()
+	default:
+		p.fail(pos, "Bad substitution")
+	}
+	exp.Name = p.src[start:p.off]
+	if exp.Length {
+		if p.ch() != '}' {
+			p.fail(pos, "Bad substitution")
+		}
+		p.advance()
+		return exp
+	}
+
+	for _, op := range []string{":-", ":=", ":?", ":+", "-", "=", "?", "+", "%%", "%", "##", "#"} {
+		if p.fill(len(op)) && strings.HasPrefix(p.src[p.off:], op) {
+			exp.Op = op
+			for range op {
+				p.advance()
+			}
+			break
+		}
+	}
+	if exp.Op != "" {
+		exp.Word = p.braceWord(pos)
+	}
+	switch p.ch() {
+	case 0:
+		p.fail(pos, "Missing '}'")
+	case '}':
+	default:
+		p.fail(pos, "Bad substitution")
+	}
+	p.advance()
+	return exp
+}
+
+// braceWord is the word in ${NAME-word}, up to the closing brace, which
+// can have quotes and expansions of its own
+func (p *parser) braceWord(pos Pos) *Word {
+	return p.word(p.pos(), func(c byte) bool {
+		if c == 0 {
+			p.fail(pos, "Missing '}'")
+		}
+		return c == '}'
+	})
+}
+
+// Grammar
+
+// isReserved is the current token being the reserved word word
+func (p *parser) isReserved(word string) bool {
+	if p.tok.kind != tWord {
+		return false
+	}
+	lit, ok := p.tok.word.Lit()
+	return ok && lit == word
+}
+
+// closes is the current token ending a list rather than starting a command
+func (p *parser) closes() bool {
+	switch p.tok.kind {
+	case tEOF, tRParen, tDSemi:
+		return true
+	case tWord:
+		for _, word := range []string{"then", "else", "elif", "fi", "do", "done", "esac", "}"} {
+			if p.isReserved(word) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startsCommand is the current token being where a command could start
+func (p *parser) startsCommand() bool {
+	return p.tok.kind == tWord && !p.closes() || p.tok.kind == tLParen || p.tok.kind == tRedir
+}
+
+// linebreak skips any newlines
+func (p *parser) linebreak() {
+	for p.tok.kind == tNewline {
+		p.next()
+	}
+}
+
+// expect the reserved word word, and move past it
+func (p *parser) expect(word string) {
+	if !p.isReserved(word) {
+		if p.tok.kind == tEOF {
+			p.fail(p.tok.pos, "end of file unexpected (expecting %q)", word)
+		}
+		p.fail(p.tok.pos, "%q unexpected (expecting %q)", p.tok.text, word)
+	}
+	p.next()
+}
+
+// list is a compound list: and-ors separated by ;, & or newlines, up to
+// whatever closes it
+func (p *parser) list() *List {
+	p.linebreak()
+	list := &List{Pos: p.tok.pos}
+	for p.startsCommand() {
+		item := p.andOr()
+		list.Items = append(list.Items, item)
+		switch p.tok.kind {
+		case tAmp:
+			item.Async = true
+			p.next()
+		case tSemi:
+			p.next()
+		case tNewline:
+		default:
+			if !p.closes() {
+				p.unexpected()
+			}
+		}
+		p.linebreak()
+	}
+	return list
+}
+
+// nonEmpty is a list that has to have a command in it, followed by next
+func (p *parser) nonEmpty(next string) *List {
+	list := p.list()
+	if len(list.Items) == 0 {
+		p.expecting(next)
+	}
+	return list
+}
+
+// expecting fails on the current token, which isn't next
+func (p *parser) expecting(next string) {
+	if p.tok.kind == tEOF {
+		p.fail(p.tok.pos, "end of file unexpected (expecting %q)", next)
+	}
+	p.unexpected()
+}
+
+func (p *parser) andOr() *AndOr {
+	andOr := &AndOr{Pipelines: []*Pipeline{p.pipeline()}}
+	for p.tok.kind == tAndIf || p.tok.kind == tOrIf {
+		andOr.Ops = append(andOr.Ops, p.tok.text)
+		p.next()
+		p.linebreak()
+		andOr.Pipelines = append(andOr.Pipelines, p.pipeline())
+	}
+	return andOr
+}
+
+func (p *parser) pipeline() *Pipeline {
+	pipeline := &Pipeline{Pos: p.tok.pos}
+	if p.isReserved("!") {
+		pipeline.Bang = true
+		p.next()
+	}
+	pipeline.Commands = append(pipeline.Commands, p.commandNode())
+	for p.tok.kind == tPipe {
+		p.next()
+		p.linebreak()
+		pipeline.Commands = append(pipeline.Commands, p.commandNode())
+	}
+	return pipeline
+}
+
+func (p *parser) commandNode() Command {
+	pos := p.tok.pos
+	var cmd Command
+	switch {
+	case p.tok.kind == tLParen:
+		p.next()
+		body := p.nonEmpty(")")
+		if p.tok.kind != tRParen {
+			p.expecting(")")
+		}
+		p.next()
+		cmd = &Subshell{Pos: pos, Body: body}
+	case p.tok.kind == tRedir:
+		return p.simpleCommand()
+	case p.tok.kind != tWord:
+		p.unexpected()
+	case p.isReserved("{"):
+		p.next()
+		body := p.nonEmpty("}")
+		p.expect("}")
+		cmd = &BraceGroup{Pos: pos, Body: body}
+	case p.isReserved("if"):
+		cmd = p.ifClause()
+	case p.isReserved("while"), p.isReserved("until"):
+		cmd = p.whileClause()
+	case p.isReserved("for"):
+		cmd = p.forClause()
+	case p.isReserved("case"):
+		cmd = p.caseClause()
+	case p.closes(), p.isReserved("!"):
+		p.unexpected()
+	default:
+		if name, ok := p.tok.word.Lit(); ok && IsName(name) && p.peek().kind == tLParen {
+			return p.funcDecl(name)
+		}
+		return p.simpleCommand()
+	}
+	if p.tok.kind != tRedir {
+		return cmd
+	}
+	redirected := &Redirected{Pos: pos, Command: cmd}
+	for p.tok.kind == tRedir {
+		redirected.Redirs = append(redirected.Redirs, p.redirect())
+	}
+	return redirected
+}
+
+func (p *parser) ifClause() Command {
+	clause := &IfClause{Pos: p.tok.pos}
+	p.next()
+	for {
+		cond := p.nonEmpty("then")
+		p.expect("then")
+		clause.Clauses = append(clause.Clauses, &CondBody{Cond: cond, Body: p.nonEmpty("fi")})
+		if !p.isReserved("elif") {
+			break
+		}
+		p.next()
+	}
+	if p.isReserved("else") {
+		p.next()
+		clause.Else = p.nonEmpty("fi")
+	}
+	p.expect("fi")
+	return clause
+}
+
+func (p *parser) whileClause() Command {
+	clause := &WhileClause{Pos: p.tok.pos, Until: p.isReserved("until")}
+	p.next()
+	clause.Cond = p.nonEmpty("do")
+	clause.Body = p.doGroup()
+	return clause
+}
+
+// doGroup is do list done
+func (p *parser) doGroup() *List {
+	p.expect("do")
+	body := p.nonEmpty("done")
+	p.expect("done")
+	return body
+}
+
+func (p *parser) forClause() Command {
+	clause := &ForClause{Pos: p.tok.pos}
+	p.next()
+	if p.tok.kind != tWord {
+		p.fail(p.tok.pos, "Bad for loop variable")
+	}
+	if name, ok := p.tok.word.Lit(); !ok || !IsName(name) {
+		p.fail(p.tok.pos, "Bad for loop variable")
+	} else {
+		clause.Name = name
+	}
+	p.next()
+	p.linebreak()
+	if p.isReserved("in") {
+		clause.In = true
+		p.next()
+		for p.tok.kind == tWord {
+			clause.Items = append(clause.Items, p.tok.word)
+			p.next()
+		}
+		if p.tok.kind != tSemi && p.tok.kind != tNewline {
+			p.unexpected()
+		}
+		p.next()
+		p.linebreak()
+	} else if p.tok.kind == tSemi {
+		p.next()
+		p.linebreak()
+	}
+	clause.Body = p.doGroup()
+	return clause
+}
+
+func (p *parser) caseClause() Command {
+	clause := &CaseClause{Pos: p.tok.pos}
+	p.next()
+	if p.tok.kind != tWord {
+		p.unexpected()
+	}
+	clause.Word = p.tok.word
+	p.next()
+	p.linebreak()
+	p.expect("in")
+	p.linebreak()
+	for !p.isReserved("esac") {
+		item := &CaseItem{}
+		if p.tok.kind == tLParen {
+			p.next()
+		}
+		for {
+			if p.tok.kind != tWord {
+				p.expecting(")")
+			}
+			item.Patterns = append(item.Patterns, p.tok.word)
+			p.next()
+			if p.tok.kind != tPipe {
+				break
+			}
+			p.next()
+		}
+		if p.tok.kind != tRParen {
+			p.expecting(")")
+		}
+		p.next()
+		item.Body = p.list()
+		clause.Items = append(clause.Items, item)
+		if p.tok.kind != tDSemi {
+			if p.tok.kind == tEOF {
+				p.expecting(";;")
+			}
+			break
+		}
+		p.next()
+		p.linebreak()
+	}
+	p.expect("esac")
+	return clause
+}
+
+func (p *parser) funcDecl(name string) Command {
+	decl := &FuncDecl{Pos: p.tok.pos, Name: name}
+	p.next()
+	p.next()
+	if p.tok.kind != tRParen {
+		p.expecting(")")
+	}
+	p.next()
+	p.linebreak()
+	switch body := p.commandNode().(type) {
+	case *SimpleCommand, *FuncDecl:
+		p.fail(decl.Pos, "function body must be a compound command")
+	default:
+		decl.Body = body
+	}
+	return decl
+}
+
+func (p *parser) simpleCommand() Command {
+	cmd := &SimpleCommand{Pos: p.tok.pos}
+	for {
+		switch p.tok.kind {
+		case tRedir:
+			cmd.Redirs = append(cmd.Redirs, p.redirect())
+			continue
+		case tWord:
+		default:
+			return cmd
+		}
+		if len(cmd.Args) == 0 {
+			if assign := assignment(p.tok.word); assign != nil {
+				cmd.Assigns = append(cmd.Assigns, assign)
+				p.next()
+				continue
+			}
+		}
+		cmd.Args = append(cmd.Args, p.tok.word)
+		p.next()
+	}
+}
+
+// redirect is the redirection operator that's the current token, and the
+// word after it
+func (p *parser) redirect() *Redirect {
+	r := &Redirect{Pos: p.tok.pos, N: p.tok.fd, Op: p.tok.text}
+	p.next()
+	if p.tok.kind != tWord {
+		p.unexpected()
+	}
+	r.Word = p.tok.word
+	switch r.Op {
+	case "<&", ">&":
+		if lit, ok := r.Word.Lit(); ok && !isFd(lit) {
+			p.fail(r.Pos, "Bad fd number")
+		}
+	case "<<", "<<-":
+		// registered before the next token, which could be the newline
+		// the body follows
+		p.hdocs = append(p.hdocs, r)
+	}
+	p.next()
+	return r
+}
+
+// isFd is word being what can follow <& or >&: a descriptor, or -
+func isFd(word string) bool {
+	if word == "-" {
+		return true
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < '0' || word[i] > '9' {
+			return false
+		}
+	}
+	return word != ""
+}
+
+// assignment is word as NAME=value, or nil if it isn't one
+func assignment(word *Word) *Assign {
+	if len(word.Parts) == 0 {
+		return nil
+	}
+	lit, ok := word.Parts[0].(*Lit)
+	if !ok {
+		return nil
+	}
+	eq := strings.IndexByte(lit.Value, '=')
+	if eq < 0 || !IsName(lit.Value[:eq]) {
+		return nil
+	}
+	value := &Word{Pos: Pos{word.Pos.Line, word.Pos.Col + eq + 1}}
+	if rest := lit.Value[eq+1:]; rest != "" {
+		value.Parts = append(value.Parts, &Lit{Value: rest})
+	}
+	value.Parts = append(value.Parts, word.Parts[1:]...)
+	return &Assign{Pos: word.Pos, Name: lit.Value[:eq], Value: value}
+}
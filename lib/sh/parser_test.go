@@ -0,0 +1,174 @@
+package sh_test
+
+import (
+	"strings"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib/sh"
+)
+
+func dump(t *testing.T, src string) string {
+	t.Helper()
+	f, err := sh.Parse("test", src)
+	if err != nil {
+		t.Fatalf("%q: %s", src, err)
+	}
+	b := &strings.Builder{}
+	sh.Dump(b, f.Body)
+	return b.String()
+}
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		name, src, expected string
+	}{
+		{"simple", "x=1 echo a 'b c'\n", `SimpleCommand @1:1
+  Assign x=1
+  Word echo
+  Word a
+  Word 'b c'
+`},
+		{"pipeline and-or", "! a | b && c || d &", `AndOr && || &
+  Pipeline ! @1:1
+    SimpleCommand @1:3
+      Word a
+    SimpleCommand @1:7
+      Word b
+  SimpleCommand @1:12
+    Word c
+  SimpleCommand @1:17
+    Word d
+`},
+		{"quotes and expansions", `echo "a $x ${y:-"z"}" \$ $(b) ` + "`c`" + ` $((1 + $n))`, `SimpleCommand @1:1
+  Word echo
+  Word "a ${x} ${y:-"z"}"
+  Word \$
+  Word $(...)
+  Word $(...)
+  Word $((1 + ${n}))
+`},
+		{"compound", "if a; then b; elif c; then d; else e; fi\nwhile a; do b; done", `If @1:1
+  if
+    SimpleCommand @1:4
+      Word a
+  then
+    SimpleCommand @1:12
+      Word b
+  elif
+    SimpleCommand @1:20
+      Word c
+  then
+    SimpleCommand @1:28
+      Word d
+  else
+    SimpleCommand @1:36
+      Word e
+While @2:1
+  cond
+    SimpleCommand @2:7
+      Word a
+  do
+    SimpleCommand @2:13
+      Word b
+`},
+		{"for and case", "for i in a b; do :; done\ncase $x in\n(a|b) c;;\n*) ;;\nesac", `For i @1:1
+  in
+    Word a
+    Word b
+  do
+    SimpleCommand @1:18
+      Word :
+Case ${x} @2:1
+  a | b)
+    SimpleCommand @3:7
+      Word c
+  *)
+`},
+		{"function and groups", "f() { (a); }", `Func f @1:1
+  BraceGroup @1:5
+    Subshell @1:7
+      SimpleCommand @1:8
+        Word a
+`},
+		{"reserved words as arguments", "echo if then { }", `SimpleCommand @1:1
+  Word echo
+  Word if
+  Word then
+  Word {
+  Word }
+`},
+		{"redirections", "2>&1 x=1 cat <in >>out 3<>rw 4>&- >|f", `SimpleCommand @1:1
+  Assign x=1
+  Word cat
+  Redirect 2>&1
+  Redirect 0<in
+  Redirect 1>>out
+  Redirect 3<>rw
+  Redirect 4>&-
+  Redirect 1>|f
+`},
+		{"here-documents", "cat <<EOF; cat <<-'E'\na $x \\$ \"q\"\nEOF\n\tb $y\n\tE\necho after", `SimpleCommand @1:1
+  Word cat
+  Redirect 0<<EOF
+    Hdoc "\"a ${x} \\$ \"q\"\n\""
+SimpleCommand @1:12
+  Word cat
+  Redirect 0<<-'E'
+    Hdoc "'b $y\n'"
+SimpleCommand @6:1
+  Word echo
+  Word after
+`},
+		{"redirected compound", "f() { a; } >f 2>&1", `Func f @1:1
+  Redirected @1:5
+    BraceGroup @1:5
+      SimpleCommand @1:7
+        Word a
+    Redirect 1>f
+    Redirect 2>&1
+`},
+		{"comments and continuations", "# comment\necho a\\\nb # more", `SimpleCommand @2:1
+  Word echo
+  Word ab
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := dump(t, tt.src); actual != tt.expected {
+				t.Errorf("expected:\n%s\nactual:\n%s", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	var tests = []struct {
+		src, expected string
+	}{
+		{"if true", `1: Syntax error: end of file unexpected (expecting "then")`},
+		{"if true; then fi", `1: Syntax error: "fi" unexpected`},
+		{"echo a\necho )", `2: Syntax error: ")" unexpected`},
+		{"while :; do", `1: Syntax error: end of file unexpected (expecting "done")`},
+		{"case x in x) a", `1: Syntax error: end of file unexpected (expecting ";;")`},
+		{"echo 'a", `1: Syntax error: Unterminated quoted string`},
+		{"echo ${x", `1: Syntax error: Missing '}'`},
+		{"for 1 in a; do :; done", `1: Syntax error: Bad for loop variable`},
+		{"for", `1: Syntax error: Bad for loop variable`},
+		{"for )", `1: Syntax error: Bad for loop variable`},
+		{"for 1 in x", `1: Syntax error: Bad for loop variable`},
+		{"a &&", `1: Syntax error: end of file unexpected`},
+		{"echo >&x", `1: Syntax error: Bad fd number`},
+		{"echo > >f", `1: Syntax error: redirection unexpected`},
+		{"echo >", `1: Syntax error: end of file unexpected`},
+	}
+	for _, tt := range tests {
+		_, err := sh.Parse("test", tt.src)
+		if err == nil {
+			t.Errorf("%q: expected %q, parsed", tt.src, tt.expected)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%q: expected %q, actual %q", tt.src, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package sh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+// A command's file descriptors are its stdio: 0, 1 and 2 are the reader and
+// writers builtins and applets use, and 3 on are whatever was opened or
+// copied there, which only programs run from $PATH can get at.
+
+// closed is a descriptor closed with n>&-, which can't be read or written
+type closed struct{}
+
+func (closed) Read([]byte) (int, error)  { return 0, syscall.EBADF }
+func (closed) Write([]byte) (int, error) { return 0, syscall.EBADF }
+
+// copy is std with a table of its own, for redirections that only last as
+// long as a command
+func (std *stdio) copy() *stdio {
+	c := *std
+	c.fds = make(map[int]interface{}, len(std.fds))
+	for n, f := range std.fds {
+		c.fds[n] = f
+	}
+	return &c
+}
+
+// fd is descriptor n, or nil if it isn't open
+func (std *stdio) fd(n int) interface{} {
+	var f interface{}
+	switch n {
+	case 0:
+		f = std.in
+	case 1:
+		f = std.out
+	case 2:
+		f = std.err
+	default:
+		f = std.fds[n]
+	}
+	if _, ok := f.(closed); ok {
+		return nil
+	}
+	return f
+}
+
+// setFd makes f descriptor n, or closes n if f is nil.  Something that can't
+// be read as 0, or written as 1 or 2, is as good as closed.
+func (std *stdio) setFd(n int, f interface{}) {
+	switch n {
+	case 0:
+		r, ok := f.(io.Reader)
+		if !ok {
+			r = closed{}
+		}
+		std.in = r
+	case 1, 2:
+		w, ok := f.(io.Writer)
+		if !ok {
+			w = closed{}
+		}
+		if n == 1 {
+			std.out = w
+		} else {
+			std.err = w
+		}
+	default:
+		if f == nil {
+			delete(std.fds, n)
+		} else {
+			if std.fds == nil {
+				std.fds = map[int]interface{}{}
+			}
+			std.fds[n] = f
+		}
+	}
+}
+
+// redirectError is a redirection that can't be made, which fails the
+// command rather than the shell, unless it's a special builtin
+type redirectError struct {
+	msg string
+}
+
+func (e *redirectError) Error() string { return e.msg }
+
+// redirect makes redirs, in order, on a copy of std, and returns it with
+// what closes the files they opened.  If one fails, the copy has the ones
+// before it made, which is where dash reports the failure.
+func (s *Shell) redirect(std *stdio, redirs []*Redirect) (*stdio, func(), error) {
+	if len(redirs) == 0 {
+		return std, func() {}, nil
+	}
+	std = std.copy()
+	opened, err := s.redirectIn(std, redirs)
+	return std, func() {
+		for _, f := range opened {
+			f.Close()
+		}
+	}, err
+}
+
+// redirectIn makes redirs on std itself, as exec does, and returns the
+// files it opened
+func (s *Shell) redirectIn(std *stdio, redirs []*Redirect) ([]*os.File, error) {
+	var opened []*os.File
+	for _, r := range redirs {
+		if r.Hdoc != nil {
+			body, err := s.expandString(std, r.Hdoc)
+			if err != nil {
+				return opened, err
+			}
+			std.setFd(r.N, strings.NewReader(body))
+			continue
+		}
+		word, err := s.expandString(std, r.Word)
+		if err != nil {
+			return opened, err
+		}
+		if r.Op == "<&" || r.Op == ">&" {
+			if word == "-" {
+				std.setFd(r.N, nil)
+				continue
+			}
+			n, err := strconv.Atoi(word)
+			if err != nil || !isFd(word) {
+				// which dash only finds out now, but still calls a syntax error
+				return opened, expandErrorf("Syntax error: Bad fd number")
+			}
+			f := std.fd(n)
+			if f == nil {
+				return opened, &redirectError{fmt.Sprintf("%d: Bad file descriptor", n)}
+			}
+			std.setFd(r.N, f)
+			continue
+		}
+		f, err := s.open(r.Op, word)
+		if err != nil {
+			return opened, err
+		}
+		opened = append(opened, f)
+		std.setFd(r.N, f)
+	}
+	return opened, nil
+}
+
+// open opens name for the redirection op, saying why it can't the way
+// dash does
+func (s *Shell) open(op, name string) (*os.File, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch op {
+	case "<":
+		flag = os.O_RDONLY
+	case "<>":
+		flag = os.O_RDWR | os.O_CREATE
+	case ">>":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := s.create(s.path(name), flag)
+	if err == nil {
+		return f, nil
+	}
+	if op == "<" {
+		return nil, &redirectError{fmt.Sprintf("cannot open %s: %s", name, openReason(err, false))}
+	}
+	return nil, &redirectError{fmt.Sprintf("cannot create %s: %s", name, openReason(err, true))}
+}
+
+// create opens path with flag, and if that creates it, gives it the
+// permissions the shell's mask leaves rather than the process's
+func (s *Shell) create(path string, flag int) (*os.File, error) {
+	perm := os.FileMode(0666 &^ s.mask)
+	_, err := os.Stat(path)
+	created := flag&os.O_CREATE != 0 && errors.Is(err, os.ErrNotExist)
+	f, err := os.OpenFile(path, flag, perm)
+	if err == nil && created {
+		err = f.Chmod(perm)
+		if err != nil {
+			f.Close()
+			f = nil
+		}
+	}
+	return f, err
+}
+
+// openReason is why a file couldn't be opened, or created, in dash's words:
+// a path that isn't there is "No such file", or "Directory nonexistent" when
+// creating, and anything else is the error itself
+func openReason(err error, create bool) string {
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR) {
+		if create {
+			return "Directory nonexistent"
+		}
+		return "No such file"
+	}
+	return lib.OperandError("sh", "", err).Reason()
+}
+
+// redirectFailed reports err, from redirecting a command: one that can't
+// be made fails with 2, but an expansion that can't be done ends the shell
+func (s *Shell) redirectFailed(std *stdio, err error) int {
+	var redirErr *redirectError
+	if !errors.As(err, &redirErr) {
+		return s.expansionFailed(std, err)
+	}
+	s.errorf(std, "%s", err)
+	s.status = 2
+	return s.status
+}
+
+// passed are the descriptors a program run from $PATH gets, with pipes
+// standing in for those that aren't files
+type passed struct {
+	// pipes are the pipes made for each reader or writer, so one written
+	// to as both 1 and 2 still has only the one goroutine writing to it
+	pipes map[pipe]*os.File
+	// ends are the pipes' ends the program has, to close once it's started
+	ends   []*os.File
+	copies sync.WaitGroup
+}
+
+// pass sets cmd up with std's descriptors.  A closed 0, 1 or 2 is nil, and
+// so /dev/null, which is the nearest exec gets to closed.
+func (std *stdio) pass(cmd *exec.Cmd) (*passed, error) {
+	p := &passed{pipes: map[pipe]*os.File{}}
+	last := 2
+	for n := range std.fds {
+		if n > last {
+			last = n
+		}
+	}
+	files := make([]*os.File, last+1)
+	for n := range files {
+		f, err := p.file(std.fd(n), n == 0)
+		if err != nil {
+			p.started()
+			return nil, err
+		}
+		files[n] = f
+	}
+	// not straight from files, as a nil *os.File isn't a nil io.Reader
+	if files[0] != nil {
+		cmd.Stdin = files[0]
+	}
+	if files[1] != nil {
+		cmd.Stdout = files[1]
+	}
+	if files[2] != nil {
+		cmd.Stderr = files[2]
+	}
+	cmd.ExtraFiles = files[3:]
+	return p, nil
+}
+
+// pipe is a reader or writer, and which way a pipe for it goes
+type pipe struct {
+	f    interface{}
+	read bool
+}
+
+// file is what the program gets for f: f itself if it's a file, or else a
+// pipe that's copied from f if read is true or f can't be written to, and
+// to f if not
+func (p *passed) file(f interface{}, read bool) (*os.File, error) {
+	if f == nil {
+		return nil, nil
+	}
+	if file, ok := f.(*os.File); ok {
+		return file, nil
+	}
+	writer, isWriter := f.(io.Writer)
+	reader, isReader := f.(io.Reader)
+	read = isReader && (read || !isWriter)
+	key := pipe{f, read}
+	comparable := reflect.TypeOf(f).Comparable()
+	if comparable && p.pipes[key] != nil {
+		return p.pipes[key], nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	end := w
+	p.copies.Add(1)
+	if read {
+		end = r
+		go func() {
+			defer p.copies.Done()
+			io.Copy(w, reader)
+			w.Close()
+		}()
+	} else {
+		go func() {
+			defer p.copies.Done()
+			io.Copy(writer, r)
+			r.Close()
+		}()
+	}
+	p.ends = append(p.ends, end)
+	if comparable {
+		p.pipes[key] = end
+	}
+	return end, nil
+}
+
+// started closes the program's ends of the pipes, once it has them
+func (p *passed) started() {
+	for _, end := range p.ends {
+		end.Close()
+	}
+	p.ends = nil
+}
+
+// wait waits for the pipes to be done with, once the program has exited
+func (p *passed) wait() {
+	p.copies.Wait()
+}
@@ -0,0 +1,129 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+func init() {
+	lib.RegisterApplet(func() lib.Applet { return &applet{} })
+}
+
+// Options are sh's flags
+type Options struct {
+	// Command is -c: the first operand is the script, and the ones after
+	// it are $0 and the parameters
+	Command bool
+	// Stdin is -s: the script is read from standard input, and the
+	// operands are the parameters
+	Stdin bool
+	// AST dumps the parsed script instead of running it
+	AST bool
+
+	ErrExit, NoUnset, XTrace, NoGlob, NoExec bool
+}
+
+type applet struct {
+	options Options
+}
+
+func (a *applet) Name() string    { return "sh" }
+func (a *applet) Summary() string { return "command interpreter: the POSIX shell" }
+
+func (a *applet) FlagSet() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("sh", pflag.ContinueOnError)
+	// flags after the script are the script's
+	fs.SetInterspersed(false)
+	fs.BoolVarP(&a.options.Command, "c", "c", false, "read commands from the first operand")
+	fs.BoolVarP(&a.options.Stdin, "s", "s", false, "read commands from standard input")
+	lib.ShorthandOnly(fs, "c")
+	lib.ShorthandOnly(fs, "s")
+	fs.BoolVarP(&a.options.ErrExit, "errexit", "e", false, "exit when a command fails")
+	fs.BoolVarP(&a.options.NoGlob, "noglob", "f", false, "don't expand pathnames")
+	fs.BoolVarP(&a.options.NoExec, "noexec", "n", false, "read commands but don't run them")
+	fs.BoolVarP(&a.options.NoUnset, "nounset", "u", false, "fail on expanding unset variables")
+	fs.BoolVarP(&a.options.XTrace, "xtrace", "x", false, "write each command to standard error before running it")
+	fs.BoolVar(&a.options.AST, "ast", false, "print the parsed script as a tree instead of running it")
+	return fs
+}
+
+func (a *applet) Usage() lib.Usage {
+	return lib.Usage{
+		Operands: "[-c STRING [NAME [ARG]...] | [-s] [ARG]... | FILE [ARG]...]",
+		Description: `Run a shell script: the STRING given with -c, FILE, or standard input
+with -s or when there is no FILE.
+
+Builtins and functions run in the shell, and so do the slimbox applets
+compiled in; anything else is run from $PATH.`,
+		Epilogue: `Examples:
+  sh -c 'wc -l "$1"' sh f  Count the lines in f.
+  sh --ast script.sh       Show how script.sh parses.`,
+	}
+}
+
+func (a *applet) Run(ctx *lib.Context, args []string) int {
+	s := New(ctx)
+	s.opts = options{
+		errexit: a.options.ErrExit,
+		nounset: a.options.NoUnset,
+		xtrace:  a.options.XTrace,
+		noglob:  a.options.NoGlob,
+		noexec:  a.options.NoExec,
+	}
+
+	var script []byte
+	var err error
+	switch {
+	case a.options.Command:
+		if len(args) == 0 {
+			fmt.Fprintln(ctx.Stderr, "sh: 0: -c requires an argument")
+			return lib.ExitUsage
+		}
+		script = []byte(args[0])
+		if len(args) > 1 {
+			s.Name, s.Params = args[1], args[2:]
+		}
+	case len(args) > 0 && !a.options.Stdin:
+		s.Name, s.Params = args[0], args[1:]
+		if script, err = os.ReadFile(ctx.Path(args[0])); err != nil {
+			fmt.Fprintf(ctx.Stderr, "sh: 0: cannot open %s: %s\n", args[0], openReason(err, false))
+			return lib.ExitUsage
+		}
+	default:
+		s.Params = args
+		in := ctx.Stdin
+		if in == nil {
+			in = strings.NewReader("")
+		}
+		// commands only share standard input with the script when it's a
+		// real file, where whatever the script hasn't read yet is left to
+		// them, as it is with dash; a reader in the process would be
+		// drained by the first program run from $PATH
+		std := *ctx
+		if _, ok := in.(*os.File); !ok {
+			std.Stdin = nil
+		}
+		if !a.options.AST {
+			return s.RunReader(&std, in)
+		}
+		if script, err = io.ReadAll(in); err != nil {
+			return lib.Report(ctx.Stderr, lib.OperandError("sh", "-", err))
+		}
+	}
+
+	if a.options.AST {
+		f, err := Parse(s.Name, string(script))
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "%s: %s\n", s.Name, err)
+			return lib.ExitUsage
+		}
+		Dump(ctx.Stdout, f)
+		return lib.ExitSuccess
+	}
+	return s.Run(ctx, string(script))
+}
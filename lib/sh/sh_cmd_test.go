@@ -0,0 +1,321 @@
+package sh_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+	_ "gitlab.com/yarbelk/slimbox/lib/cat"
+	_ "gitlab.com/yarbelk/slimbox/lib/sh"
+	_ "gitlab.com/yarbelk/slimbox/lib/wc"
+)
+
+// run script with sh -c, in dir, with nothing on $PATH unless env says so
+func run(t *testing.T, dir, script string, env ...string) (string, string, int) {
+	t.Helper()
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx := &lib.Context{Stdin: strings.NewReader(""), Stdout: stdout, Stderr: stderr, Dir: dir, Env: env}
+	status := lib.RunContext(ctx, "sh", []string{"-c", script, "sh", "one", "two three"})
+	return stdout.String(), stderr.String(), status
+}
+
+func TestRun(t *testing.T) {
+	var tests = []struct {
+		name, script   string
+		stdout, stderr string
+		status         int
+	}{
+		{name: "parameters", script: `echo $# "$1" $2; for p in "$@"; do echo "[$p]"; done; echo "$*"`,
+			stdout: "2 one two three\n[one]\n[two three]\none two three\n"},
+		{name: "field splitting", script: `x="  a  b "; set -- $x; echo $#; IFS=,; x="a,,b"; set -- $x; echo $#; set -- "$x"; echo $#`,
+			stdout: "2\n3\n1\n"},
+		{name: "parameter expansion", script: `p=a/b.tar.gz; echo ${p##*/} ${p%%.*} ${p#a} ${p%.gz} ${#p} ${u:-d} [${u+s}] ${u=v} $u`,
+			stdout: "b.tar.gz a/b /b.tar.gz a/b.tar 10 d [] v v\n"},
+		{name: "quoted default", script: `set -- ${u-"a b"}; echo $#; set -- ${u-a b}; echo $#`,
+			stdout: "1\n2\n"},
+		{name: "arithmetic", script: `x=3; echo $((x * 2 + 1)) $((x += 1)) $x $((7 / 2)) $((7 % 2)) $((1 ? 2 : 3)) $((010 + 0x10))`,
+			stdout: "7 4 4 3 1 2 24\n"},
+		{name: "command substitution", script: `x=$(echo a; echo; echo); echo "[$x]" "$(echo "$(echo nested)")" ` + "`echo back`",
+			stdout: "[a] nested back\n"},
+		{name: "and or", script: `true && echo a || echo b; false && echo c || echo d; ! false && echo e`,
+			stdout: "a\nd\ne\n"},
+		{name: "if", script: `if false; then echo a; elif true; then echo b; else echo c; fi`, stdout: "b\n"},
+		{name: "loops", script: `i=0; while [ $i -lt 5 ]; do i=$((i+1)); [ $i = 2 ] && continue; [ $i = 4 ] && break; echo $i; done; until true; do :; done`,
+			stdout: "1\n3\n"},
+		{name: "nested break", script: `for i in 1 2; do for j in a b; do [ $j = b ] && continue 2; [ $i = 2 ] && break 2; echo $i$j; done; done`,
+			stdout: "1a\n"},
+		{name: "case", script: `for w in foo bar '*' x; do case $w in f*) echo f;; b?r|baz) echo b;; \*) echo star;; *) echo other;; esac; done`,
+			stdout: "f\nb\nstar\nother\n"},
+		{name: "functions", script: `f() { echo "$# $1"; return 3; }; f a b; echo $?; echo $1`,
+			stdout: "2 a\n3\none\n"},
+		{name: "subshell", script: `x=1; (x=2; cd /; exit 4); echo $? $x`, stdout: "4 1\n"},
+		{name: "exit", script: `echo a; exit 5; echo b`, stdout: "a\n", status: 5},
+		{name: "errexit", script: `set -e; false || true; if false; then :; fi; echo a; false; echo b`,
+			stdout: "a\n", status: 1},
+		{name: "nounset", script: `set -u; echo $nothing; echo b`,
+			stderr: "sh: 1: nothing: parameter not set\n", status: 2},
+		{name: "expansion error", script: `echo ${x?no x here}; echo b`,
+			stderr: "sh: 1: x: no x here\n", status: 2},
+		{name: "not found", script: `missing-command a; echo $?`,
+			stdout: "127\n", stderr: "sh: 1: missing-command: not found\n"},
+		{name: "syntax error", script: "echo a\necho (", stdout: "a\n",
+			stderr: "sh: 2: Syntax error: end of file unexpected (expecting \")\")\n", status: 2},
+		{name: "readonly", script: `readonly r=1; r=2; echo no`, stderr: "sh: 1: r: is read only\n", status: 2},
+		{name: "eval and set", script: `eval 'x=$((1+1))'; set -- a b c; shift 2; echo $x $# $1`, stdout: "2 1 c\n"},
+		{name: "read", script: `echo "a  b  c" | { read x y; echo "[$x][$y]"; }`, stdout: "[a][b  c]\n"},
+		{name: "test", script: `[ a = a ] && [ 1 -lt 2 ] && [ -n x ] && [ ! -z x -a \( b != c \) ] && test -d / && echo yes; [ 1 -eq x ]; echo $?`,
+			stdout: "yes\n2\n", stderr: "sh: 1: [: Illegal number: x\n"},
+		{name: "echo", script: `echo -n a; echo 'b\tc\c'; echo d`, stdout: "ab\tcd\n"},
+		{name: "here-documents", script: "x=1; cat <<EOF; cat <<'E'; cat <<-EOF\n$x \\$x \"q\" $(echo s)\nEOF\n$x\nE\n\t$x\n\tEOF\necho after",
+			stdout: "1 $x \"q\" s\n$x\n1\nafter\n"},
+		{name: "here-document to a loop", script: "while read a b; do echo \"$b $a\"; done <<EOF\n1 one\n2 two\nEOF",
+			stdout: "one 1\ntwo 2\n"},
+		{name: "duplicating", script: `exec 3>&1; { echo out; echo err >&2; } 2>&1 >/dev/null | wc -l; echo to3 >&3; exec 3>&-; echo x >&3; echo $?`,
+			stdout: "1\nto3\n2\n", stderr: "sh: 1: 3: Bad file descriptor\n"},
+		{name: "closed output", script: `echo hi >&-; echo $?`, stdout: "1\n", stderr: "sh: 1: echo: echo: I/O error\n"},
+		{name: "redirection of a special builtin", script: `: <missing; echo no`,
+			stderr: "sh: 1: cannot open missing: No such file\n", status: 2},
+		{name: "trap", script: `trap 'echo "bye $?"' EXIT; trap 'echo usr1' USR1; kill -USR1 $$; (trap 'echo sub' EXIT); false`,
+			stdout: "usr1\nsub\nbye 1\n", status: 1},
+		{name: "getopts", script: `while getopts ab: o -a -bx -c y; do echo "$o ${OPTARG-}"; done; echo $OPTIND`,
+			stdout: "a \nb x\n? \n4\n", stderr: "Illegal option -c\n"},
+		{name: "command", script: `set() { echo f; }; command set -Q; echo $?; command -v set cd; command -V wc`,
+			stdout: "2\nset\nwc is a slimbox applet\n", stderr: "sh: 1: set: Illegal option -Q\n"},
+		{name: "xtrace", script: `set -x; x=1; echo "$x" b`, stdout: "1 b\n", stderr: "+ x=1\n+ echo 1 b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, status := run(t, t.TempDir(), tt.script)
+			if stdout != tt.stdout {
+				t.Errorf("stdout: expected %q, actual %q", tt.stdout, stdout)
+			}
+			if stderr != tt.stderr {
+				t.Errorf("stderr: expected %q, actual %q", tt.stderr, stderr)
+			}
+			if status != tt.status {
+				t.Errorf("status: expected %d, actual %d", tt.status, status)
+			}
+		})
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.go", ".hidden.txt", "d/e.txt"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stdout, stderr, _ := run(t, dir, `echo *.txt; echo */*; echo [!a].* "*.txt" \*.go none*; x='*.go'; echo $x "$x"; set -f; echo *.go`)
+	expected := "a.txt b.txt\nd/e.txt\nb.txt c.go *.txt *.go none*\nc.go *.go\n*.go\n"
+	if stdout != expected || stderr != "" {
+		t.Errorf("expected %q, actual %q %q", expected, stdout, stderr)
+	}
+}
+
+// Applets run in-process through the registry, so need nothing on $PATH,
+// and see the shell's directory and exported variables
+func TestRunsAppletsInProcess(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, status := run(t, dir, `wc -l f; echo a b | wc -w; cd sub && wc -l ../f; x=in-process sh -c 'echo $x'; wc -l missing; echo $?`)
+	expected := "2 f\n2\n2 ../f\nin-process\n1\n"
+	if stdout != expected {
+		t.Errorf("expected %q, actual %q", expected, stdout)
+	}
+	if expectedErr := "wc: missing: No such file or directory\n"; stderr != expectedErr || status != 0 {
+		t.Errorf("expected %q and 0, actual %q and %d", expectedErr, stderr, status)
+	}
+}
+
+// Anything that isn't a builtin, function or applet is run from $PATH
+func TestRunsExternalCommands(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"external $1 $X $(pwd)\"\nexit 3\n"
+	if err := os.WriteFile(filepath.Join(dir, "ext"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, status := run(t, dir, `X=exported ext arg; echo $?; ./ext | wc -l`, "PATH="+dir)
+	expected := "external arg exported " + dir + "\n3\n1\n"
+	if stdout != expected || stderr != "" || status != 0 {
+		t.Errorf("expected %q, actual %q %q %d", expected, stdout, stderr, status)
+	}
+}
+
+// -c and -s have no long options, while the script's arguments are its own
+func TestShorthandOnlyFlags(t *testing.T) {
+	for _, flag := range []string{"--c", "--s"} {
+		stderr := &bytes.Buffer{}
+		ctx := &lib.Context{Stdout: &bytes.Buffer{}, Stderr: stderr}
+		if status := lib.RunContext(ctx, "sh", []string{flag, "echo"}); status != lib.ExitUsage {
+			t.Errorf("%s: expected exit %d, actual %d", flag, lib.ExitUsage, status)
+		}
+		if !strings.HasPrefix(stderr.String(), "sh: unknown flag: "+flag+"\n") {
+			t.Errorf("%s: expected unknown flag, actual %q", flag, stderr)
+		}
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx := &lib.Context{Stdout: stdout, Stderr: stderr}
+	if status := lib.RunContext(ctx, "sh", []string{"-c", `echo "$@"`, "sh", "--c", "--s"}); status != 0 || stdout.String() != "--c --s\n" {
+		t.Errorf("expected the script's --c --s, actual %q %q %d", stdout, stderr, status)
+	}
+	help := &bytes.Buffer{}
+	if err := lib.Help(help, "sh"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(help.String(), "--c") || strings.Contains(help.String(), "--s ") {
+		t.Errorf("expected no --c or --s in:\n%s", help)
+	}
+}
+
+// A subshell is its own process to dash, so the umask it sets goes no
+// further, while files and programs still get the one the shell has
+func TestUmaskStaysInSubshells(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ext"), []byte("#!/bin/sh\numask\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	saved := syscall.Umask(022)
+	defer syscall.Umask(saved)
+	stdout, stderr, status := run(t, dir, "(umask 077); echo | (umask 077); umask; umask 027; ./ext; sh -c umask; umask 0; : >f")
+	if expected := "0022\n0027\n0027\n"; stdout != expected || stderr != "" || status != 0 {
+		t.Errorf("expected %q, actual %q %q %d", expected, stdout, stderr, status)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "f")); err != nil || info.Mode().Perm() != 0666 {
+		t.Errorf("expected f created 0666, actual %v %v", info.Mode(), err)
+	}
+	if mask := syscall.Umask(022); mask != 022 {
+		t.Errorf("expected the process's umask left 0022, actual %04o", mask)
+	}
+}
+
+// The process has the one set of signal handlers, so what a subshell, or
+// the shell, traps is put back when it's done
+func TestTrapsStayInSubshells(t *testing.T) {
+	stdout, stderr, status := run(t, t.TempDir(), "(trap '' USR1); echo | (trap '' USR1); trap '' USR2; trap 'echo' HUP; trap")
+	if expected := "trap -- 'echo' HUP\ntrap -- '' USR2\n"; stdout != expected || stderr != "" || status != 0 {
+		t.Errorf("expected %q, actual %q %q %d", expected, stdout, stderr, status)
+	}
+	// signal.Ignored can't tell, as it goes on saying they are
+	proc, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, line := range strings.Split(string(proc), "\n") {
+		if strings.HasPrefix(line, "SigIgn:") {
+			ignored, _ := strconv.ParseUint(strings.TrimSpace(line[len("SigIgn:"):]), 16, 64)
+			for _, sig := range []syscall.Signal{syscall.SIGUSR1, syscall.SIGUSR2} {
+				if ignored&(1<<(sig-1)) != 0 {
+					t.Errorf("expected %s no longer ignored", sig)
+				}
+			}
+		}
+	}
+}
+
+// A script that can't be read says why, with a path that isn't there being
+// "No such file" as it is to dash
+func TestScriptOpenFailures(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "f"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, reason := range map[string]string{"missing": "No such file", "f/x": "No such file", "d": "Is a directory"} {
+		stderr := &bytes.Buffer{}
+		ctx := &lib.Context{Stdout: &bytes.Buffer{}, Stderr: stderr, Dir: dir}
+		if status := lib.RunContext(ctx, "sh", []string{name}); status != lib.ExitUsage {
+			t.Errorf("%s: expected exit %d, actual %d", name, lib.ExitUsage, status)
+		}
+		if expected := "sh: 0: cannot open " + name + ": " + reason + "\n"; stderr.String() != expected {
+			t.Errorf("%s: expected %q, actual %q", name, expected, stderr)
+		}
+	}
+}
+
+// Standard input runs a complete command at a time, each before the next is
+// even written, the way it would be typed
+func TestRunsStdinAsItComes(t *testing.T) {
+	in, script := io.Pipe()
+	out, stdout := io.Pipe()
+	stderr := &bytes.Buffer{}
+	ctx := &lib.Context{Stdin: in, Stdout: stdout, Stderr: stderr, Dir: t.TempDir()}
+	done := make(chan int, 1)
+	go func() {
+		done <- lib.RunContext(ctx, "sh", nil)
+		stdout.Close()
+	}()
+	lines := bufio.NewReader(out)
+	for _, step := range []struct{ write, expected string }{
+		{"echo one\n", "one\n"},
+		{"if true\nthen echo two\n", ""},
+		{"fi; cat <<EOF\n", ""},
+		{"three\nEOF\n", "two\nthree\n"},
+	} {
+		if _, err := io.WriteString(script, step.write); err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range strings.SplitAfter(step.expected, "\n") {
+			if expected == "" {
+				continue
+			}
+			if actual, err := lines.ReadString('\n'); actual != expected {
+				t.Fatalf("after %q: expected %q, actual %q %v", step.write, expected, actual, err)
+			}
+		}
+	}
+	script.Close()
+	if rest, _ := io.ReadAll(lines); len(rest) != 0 {
+		t.Errorf("expected nothing more, actual %q", rest)
+	}
+	if status := <-done; status != 0 || stderr.Len() != 0 {
+		t.Errorf("expected exit 0, actual %d %q", status, stderr)
+	}
+}
+
+func TestRedirections(t *testing.T) {
+	dir := t.TempDir()
+	stdout, stderr, status := run(t, dir, `echo a >f; echo b >>f; wc -l <f; cat f missing >g 2>&1; `+
+		`for i in 1 2; do echo $i; done >loop; : >f; wc -c f; cat <missing; echo $?; echo c >/`)
+	expected, expectedErr := "2\n0 f\n2\n", "sh: 1: cannot open missing: No such file\nsh: 1: cannot create /: Is a directory\n"
+	if stdout != expected || stderr != expectedErr || status != 2 {
+		t.Errorf("expected %q %q 2, actual %q %q %d", expected, expectedErr, stdout, stderr, status)
+	}
+	for name, expected := range map[string]string{"g": "a\nb\ncat: missing: No such file or directory\n", "loop": "1\n2\n", "f": ""} {
+		if actual, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(actual) != expected {
+			t.Errorf("%s: expected %q, actual %q %v", name, expected, actual, err)
+		}
+	}
+}
+
+// Programs from $PATH get the descriptors past 2 too, through pipes when
+// they aren't files, like a here-document or $(...)
+func TestRedirectsExternalCommands(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\nread line; read four <&4; echo \"read $line $four\"; echo err >&2; echo three >&3\n"
+	if err := os.WriteFile(filepath.Join(dir, "ext"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, status := run(t, dir, "x=$(ext 3>&1 2>err 4<<EOF <<IN\nfour\nEOF\nin\nIN\n); echo \"[$x]\"; cat err", "PATH="+dir)
+	expected := "[read in four\nthree]\nerr\n"
+	if stdout != expected || stderr != "" || status != 0 {
+		t.Errorf("expected %q, actual %q %q %d", expected, stdout, stderr, status)
+	}
+}
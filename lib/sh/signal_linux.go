@@ -0,0 +1,15 @@
+package sh
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// defaultSignal gives sig its default action again.  os/signal can't once
+// it has ignored it: Reset leaves it ignored, and takes SIGHUP or SIGINT for
+// having been ignored from the start.
+func defaultSignal(sig syscall.Signal) {
+	// a struct sigaction of all zeros is SIG_DFL, with no flags or mask
+	var action [4]uint64
+	syscall.RawSyscall6(syscall.SYS_RT_SIGACTION, uintptr(sig), uintptr(unsafe.Pointer(&action)), 0, 8, 0, 0)
+}
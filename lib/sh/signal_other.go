@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package sh
+
+import "syscall"
+
+// defaultSignal would give sig its default action again, which os/signal
+// can't once it has ignored it; elsewhere than linux it stays ignored
+func defaultSignal(sig syscall.Signal) {}
@@ -0,0 +1,237 @@
+package sh
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// test, and [, which wants a ] at the end, work out a conditional
+// expression: 0 is true, 1 false, and 2 an expression that doesn't parse
+func test(s *Shell, std *stdio, args []string) int {
+	name := args[0]
+	args = args[1:]
+	if name == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			s.errorf(std, "[: missing ]")
+			return 2
+		}
+		args = args[:len(args)-1]
+	}
+	t := &tester{s: s, std: std, name: name, args: args}
+	ok, failed := t.run()
+	switch {
+	case failed:
+		return 2
+	case ok:
+		return 0
+	}
+	return 1
+}
+
+type tester struct {
+	s    *Shell
+	std  *stdio
+	name string
+	args []string
+	i    int
+	// failed is a syntax error, or a number that isn't one
+	failed bool
+}
+
+func (t *tester) run() (bool, bool) {
+	if len(t.args) == 0 {
+		return false, false
+	}
+	ok := t.or()
+	if !t.failed && t.i < len(t.args) {
+		t.fail("%s: unexpected operator", t.args[t.i])
+	}
+	return ok, t.failed
+}
+
+func (t *tester) fail(format string, args ...interface{}) {
+	if !t.failed {
+		t.s.errorf(t.std, t.name+": "+format, args...)
+	}
+	t.failed = true
+}
+
+func (t *tester) left() int { return len(t.args) - t.i }
+
+func (t *tester) or() bool {
+	ok := t.and()
+	for !t.failed && t.left() > 0 && t.args[t.i] == "-o" {
+		t.i++
+		right := t.and()
+		ok = ok || right
+	}
+	return ok
+}
+
+func (t *tester) and() bool {
+	ok := t.not()
+	for !t.failed && t.left() > 0 && t.args[t.i] == "-a" {
+		t.i++
+		right := t.not()
+		ok = ok && right
+	}
+	return ok
+}
+
+func (t *tester) not() bool {
+	// ! = x is comparing "!", as three arguments with a binary operator in
+	// the middle always are
+	if t.left() >= 2 && t.args[t.i] == "!" && !(t.left() >= 3 && binaryTests[t.args[t.i+1]]) {
+		t.i++
+		return !t.not()
+	}
+	return t.primary()
+}
+
+func (t *tester) primary() bool {
+	if t.left() == 0 {
+		t.fail("argument expected")
+		return false
+	}
+	arg := t.args[t.i]
+	switch {
+	case t.left() >= 3 && binaryTests[t.args[t.i+1]]:
+		op, right := t.args[t.i+1], t.args[t.i+2]
+		t.i += 3
+		return t.binary(arg, op, right)
+	case arg == "(" && t.left() >= 2:
+		t.i++
+		ok := t.or()
+		if t.left() == 0 || t.args[t.i] != ")" {
+			t.fail("closing paren expected")
+			return false
+		}
+		t.i++
+		return ok
+	case len(arg) == 2 && arg[0] == '-' && t.left() >= 2 && isUnaryTest(arg[1]):
+		operand := t.args[t.i+1]
+		t.i += 2
+		return t.unary(arg[1], operand)
+	}
+	t.i++
+	return arg != ""
+}
+
+var binaryTests = map[string]bool{
+	"=": true, "!=": true, "<": true, ">": true,
+	"-eq": true, "-ne": true, "-gt": true, "-ge": true, "-lt": true, "-le": true,
+	"-nt": true, "-ot": true, "-ef": true,
+}
+
+func isUnaryTest(op byte) bool {
+	switch op {
+	case 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'L', 'n', 'p', 'r', 'S', 's', 't', 'u', 'w', 'x', 'z':
+		return true
+	}
+	return false
+}
+
+func (t *tester) number(arg string) int64 {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		t.fail("Illegal number: %s", arg)
+	}
+	return n
+}
+
+func (t *tester) binary(left, op, right string) bool {
+	switch op {
+	case "=":
+		return left == right
+	case "!=":
+		return left != right
+	case "<":
+		return left < right
+	case ">":
+		return left > right
+	case "-nt", "-ot", "-ef":
+		l, lerr := os.Stat(t.s.path(left))
+		r, rerr := os.Stat(t.s.path(right))
+		switch op {
+		case "-nt":
+			return lerr == nil && (rerr != nil || l.ModTime().After(r.ModTime()))
+		case "-ot":
+			return rerr == nil && (lerr != nil || l.ModTime().Before(r.ModTime()))
+		}
+		return lerr == nil && rerr == nil && os.SameFile(l, r)
+	}
+	x, y := t.number(left), t.number(right)
+	switch op {
+	case "-eq":
+		return x == y
+	case "-ne":
+		return x != y
+	case "-gt":
+		return x > y
+	case "-ge":
+		return x >= y
+	case "-lt":
+		return x < y
+	}
+	return x <= y
+}
+
+func (t *tester) unary(op byte, arg string) bool {
+	switch op {
+	case 'n':
+		return arg != ""
+	case 'z':
+		return arg == ""
+	case 't':
+		fd := t.number(arg)
+		var f interface{}
+		switch fd {
+		case 0:
+			f = t.std.in
+		case 1:
+			f = t.std.out
+		case 2:
+			f = t.std.err
+		}
+		file, ok := f.(*os.File)
+		if !ok {
+			return false
+		}
+		info, err := file.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0
+	case 'r', 'w', 'x':
+		mode := map[byte]uint32{'r': 4, 'w': 2, 'x': 1}[op]
+		return syscall.Access(t.s.path(arg), mode) == nil
+	case 'h', 'L':
+		info, err := os.Lstat(t.s.path(arg))
+		return err == nil && info.Mode()&os.ModeSymlink != 0
+	}
+	info, err := os.Stat(t.s.path(arg))
+	if err != nil {
+		return false
+	}
+	mode := info.Mode()
+	switch op {
+	case 'b':
+		return mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0
+	case 'c':
+		return mode&os.ModeCharDevice != 0
+	case 'd':
+		return mode.IsDir()
+	case 'f':
+		return mode.IsRegular()
+	case 'g':
+		return mode&os.ModeSetgid != 0
+	case 'p':
+		return mode&os.ModeNamedPipe != 0
+	case 'S':
+		return mode&os.ModeSocket != 0
+	case 's':
+		return info.Size() > 0
+	case 'u':
+		return mode&os.ModeSetuid != 0
+	}
+	// -e
+	return true
+}
@@ -0,0 +1,349 @@
+package sh
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+// signalNames are the signals trap and kill know by name, which like dash's
+// are without the SIG
+var signalNames = map[string]syscall.Signal{
+	"HUP": syscall.SIGHUP, "INT": syscall.SIGINT, "QUIT": syscall.SIGQUIT, "ILL": syscall.SIGILL,
+	"TRAP": syscall.SIGTRAP, "ABRT": syscall.SIGABRT, "BUS": syscall.SIGBUS, "FPE": syscall.SIGFPE,
+	"KILL": syscall.SIGKILL, "USR1": syscall.SIGUSR1, "SEGV": syscall.SIGSEGV, "USR2": syscall.SIGUSR2,
+	"PIPE": syscall.SIGPIPE, "ALRM": syscall.SIGALRM, "TERM": syscall.SIGTERM, "CHLD": syscall.SIGCHLD,
+	"CONT": syscall.SIGCONT, "STOP": syscall.SIGSTOP, "TSTP": syscall.SIGTSTP, "TTIN": syscall.SIGTTIN,
+	"TTOU": syscall.SIGTTOU, "URG": syscall.SIGURG, "XCPU": syscall.SIGXCPU, "XFSZ": syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM, "PROF": syscall.SIGPROF, "WINCH": syscall.SIGWINCH, "IO": syscall.SIGIO,
+	"SYS": syscall.SIGSYS,
+}
+
+// signalName is sig's name, or its number if it hasn't got one
+func signalName(sig syscall.Signal) string {
+	for name, named := range signalNames {
+		if named == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// parseSignal is the signal named or numbered spec
+func parseSignal(spec string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		return syscall.Signal(n), n >= 0 && n <= 64
+	}
+	sig, ok := signalNames[spec]
+	return sig, ok
+}
+
+// trap sets the action run when each signal comes in, or as the shell exits
+// for EXIT or 0.  An action of - puts the signals back how they were, and an
+// empty one ignores them; with no arguments, trap lists the actions set.
+func (s *Shell) trap(std *stdio, args []string) int {
+	args = args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		conditions := make([]int, 0, len(s.traps))
+		for n := range s.traps {
+			conditions = append(conditions, n)
+		}
+		sort.Ints(conditions)
+		for _, n := range conditions {
+			name := "EXIT"
+			if n != 0 {
+				name = signalName(syscall.Signal(n))
+			}
+			fmt.Fprintf(std.out, "trap -- %s %s\n", shellQuote(s.traps[n]), name)
+		}
+		return 0
+	}
+	action, conditions := args[0], args[1:]
+	reset := action == "-"
+	// an action that's a number is the first condition, to put back
+	if _, err := strconv.ParseUint(action, 10, 32); err == nil {
+		reset, conditions = true, args
+	}
+	status := 0
+	for _, condition := range conditions {
+		sig, ok := syscall.Signal(0), condition == "EXIT"
+		if !ok {
+			sig, ok = parseSignal(condition)
+		}
+		if !ok {
+			s.errorf(std, "trap: %s: bad trap", condition)
+			status = 1
+			continue
+		}
+		s.setTrap(sig, action, reset)
+	}
+	return status
+}
+
+// disposition is what the shells in the process want done with a signal.
+// There's the one set of signal handlers between all of them, subshells
+// included, so a signal is only ignored while no shell has an action for
+// it, and goes back how it was once none is trapping it.
+type disposition struct {
+	notified map[chan os.Signal]bool
+	ignoring int
+	// ignored is whether it's been ignored, which os/signal can't undo
+	// by itself, and initially whether it already was the first time.
+	// signal.Ignored can't say after that, as it still does once undone.
+	ignored, initially, checked bool
+}
+
+var dispositions = struct {
+	sync.Mutex
+	signals map[syscall.Signal]*disposition
+}{signals: map[syscall.Signal]*disposition{}}
+
+// disposed is sig's disposition, for a shell about to change it.  Hold
+// dispositions while using it.
+func disposed(sig syscall.Signal) *disposition {
+	d := dispositions.signals[sig]
+	if d == nil {
+		d = &disposition{notified: map[chan os.Signal]bool{}}
+		dispositions.signals[sig] = d
+	}
+	return d
+}
+
+// notify sends sig to ch as it comes in
+func notify(ch chan os.Signal, sig syscall.Signal) {
+	dispositions.Lock()
+	defer dispositions.Unlock()
+	disposed(sig).notified[ch] = true
+	signal.Notify(ch, sig)
+}
+
+// stopNotify undoes notify
+func stopNotify(ch chan os.Signal, sig syscall.Signal) {
+	dispositions.Lock()
+	defer dispositions.Unlock()
+	d := disposed(sig)
+	delete(d.notified, ch)
+	if len(d.notified) == 0 && d.ignoring > 0 {
+		d.ignore(sig)
+	}
+	signal.Stop(ch)
+	d.settle(sig)
+}
+
+// ignore sig, unless a shell has an action for it
+func ignore(sig syscall.Signal) {
+	dispositions.Lock()
+	defer dispositions.Unlock()
+	d := disposed(sig)
+	d.ignoring++
+	if len(d.notified) == 0 {
+		d.ignore(sig)
+	}
+}
+
+// unignore undoes ignore
+func unignore(sig syscall.Signal) {
+	dispositions.Lock()
+	defer dispositions.Unlock()
+	d := disposed(sig)
+	d.ignoring--
+	d.settle(sig)
+}
+
+func (d *disposition) ignore(sig syscall.Signal) {
+	if !d.checked {
+		d.checked, d.initially = true, signal.Ignored(sig)
+	}
+	d.ignored = true
+	signal.Ignore(sig)
+}
+
+// settle puts sig back how it was once no shell is trapping it
+func (d *disposition) settle(sig syscall.Signal) {
+	if len(d.notified) > 0 || d.ignoring > 0 {
+		return
+	}
+	if d.ignored && !d.initially {
+		signal.Reset(sig)
+		defaultSignal(sig)
+	}
+	d.ignored = false
+}
+
+// setTrap makes action the trap for sig, or with reset takes it away
+func (s *Shell) setTrap(sig syscall.Signal, action string, reset bool) {
+	if s.traps == nil {
+		s.traps = map[int]string{}
+	}
+	if sig != 0 {
+		old, trapped := s.traps[int(sig)]
+		ignored, caught := trapped && old == "", trapped && old != ""
+		// what's new goes in first, so the signal isn't left to kill the
+		// shell between the two
+		switch {
+		case reset:
+		case action == "" && !ignored:
+			ignore(sig)
+		case action != "" && !caught:
+			if s.signals == nil {
+				s.signals = map[int]chan os.Signal{}
+			}
+			s.signals[int(sig)] = make(chan os.Signal, 1)
+			notify(s.signals[int(sig)], sig)
+		}
+		if ignored && (reset || action != "") {
+			unignore(sig)
+		}
+		if caught && (reset || action == "") {
+			stopNotify(s.signals[int(sig)], sig)
+			delete(s.signals, int(sig))
+		}
+	}
+	if reset {
+		delete(s.traps, int(sig))
+		return
+	}
+	s.traps[int(sig)] = action
+}
+
+// untrap takes away the shell's traps on signals, as it finishes
+func (s *Shell) untrap() {
+	for n := range s.traps {
+		if n != 0 {
+			s.setTrap(syscall.Signal(n), "", true)
+		}
+	}
+}
+
+// trapped runs the actions for the signals that have come in, which waits
+// until the command running when they did is done
+func (s *Shell) trapped(std *stdio) {
+	if len(s.signals) == 0 {
+		return
+	}
+	pending := make([]int, 0, len(s.signals))
+	for n, ch := range s.signals {
+		select {
+		case <-ch:
+			pending = append(pending, n)
+		default:
+		}
+	}
+	sort.Ints(pending)
+	for _, n := range pending {
+		if action := s.traps[n]; action != "" {
+			s.runTrap(std, action)
+		}
+	}
+}
+
+// exited runs the EXIT trap, as the shell or a subshell finishes, and then
+// gives up its traps on signals
+func (s *Shell) exited(std *stdio) {
+	action := s.traps[0]
+	delete(s.traps, 0)
+	if action != "" {
+		s.flow = flowNone
+		s.runTrap(std, action)
+	}
+	s.untrap()
+}
+
+// runTrap runs action, which leaves $? as it was unless it exits
+func (s *Shell) runTrap(std *stdio, action string) {
+	status := s.status
+	s.source(std, "trap", action)
+	if s.flow != flowExit {
+		s.status = status
+	}
+}
+
+// kill sends a signal, TERM or the one given with -s NAME, -NAME or -N, to
+// each process.  kill -l lists the signals, or names the one that killed a
+// command that exited with the status given.  There are no jobs to kill, as
+// commands run with & aren't processes of their own.
+func (s *Shell) kill(std *stdio, args []string) int {
+	args = args[1:]
+	sig := syscall.SIGTERM
+	if len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && args[0] != "--" {
+		spec := args[0][1:]
+		args = args[1:]
+		switch spec {
+		case "l":
+			return s.listSignals(std, args)
+		case "s":
+			if len(args) == 0 {
+				s.errorf(std, "kill: No arg for -s option")
+				return 2
+			}
+			spec, args = args[0], args[1:]
+		}
+		var ok bool
+		if sig, ok = parseSignal(spec); !ok {
+			s.errorf(std, "kill: invalid signal number or name: %s", spec)
+			return 2
+		}
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		s.errorf(std, "kill: Usage: kill [-s sigspec | -signum | -sigspec] [pid | job]... or\nkill -l [exitstatus]")
+		return 2
+	}
+	status := 0
+	for _, arg := range args {
+		pid, err := strconv.Atoi(arg)
+		if err != nil {
+			s.errorf(std, "kill: Illegal number: %s", arg)
+			return 2
+		}
+		// a trapped signal to the shell itself is run before the next
+		// command, as it would be if it were its own process
+		if ch := s.signals[int(sig)]; ch != nil && pid == os.Getpid() {
+			select {
+			case ch <- sig:
+			default:
+			}
+			continue
+		}
+		if err := syscall.Kill(pid, sig); err != nil {
+			s.errorf(std, "kill: %s", lib.OperandError("kill", arg, err).Reason())
+			status = 1
+		}
+	}
+	return status
+}
+
+// listSignals is kill -l
+func (s *Shell) listSignals(std *stdio, args []string) int {
+	if len(args) == 0 {
+		for n := 0; n < 32; n++ {
+			fmt.Fprintln(std.out, signalName(syscall.Signal(n)))
+		}
+		return 0
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		s.errorf(std, "kill: Illegal number: %s", args[0])
+		return 2
+	}
+	if n > 128 {
+		n -= 128
+	}
+	if n < 1 || n > 64 {
+		s.errorf(std, "kill: invalid signal number or exit status: %s", args[0])
+		return 2
+	}
+	fmt.Fprintln(std.out, signalName(syscall.Signal(n)))
+	return 0
+}
@@ -3,11 +3,35 @@ package truthy
 import (
 	"os"
 
+	"github.com/spf13/pflag"
 	"gitlab.com/yarbelk/slimbox/lib"
 )
 
 func init() {
-	lib.RegisterFunction("true")
+	lib.RegisterApplet(func() lib.Applet { return applet{} })
+}
+
+type applet struct{}
+
+func (applet) Name() string    { return "true" }
+func (applet) Summary() string { return "do nothing, successfully" }
+
+func (applet) Usage() lib.Usage {
+	return lib.Usage{
+		Operands:    "[ignored command line arguments]",
+		Description: "Exit with a status code indicating success.",
+	}
+}
+
+// FlagSet accepts anything; true ignores its arguments
+func (applet) FlagSet() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("true", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	return fs
+}
+
+func (applet) Run(ctx *lib.Context, args []string) int {
+	return 0
 }
 
 // True is only true.  it only exits well
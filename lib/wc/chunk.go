@@ -0,0 +1,146 @@
+package wc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// minChunkSize is the least worth handing to a worker of its own
+const minChunkSize = 4 << 20
+
+// countChunks counts from start to end of f in up to n pieces at once, with
+// pread, and adds them up.  The pieces are split where the counts can be put
+// back together: lines anywhere; words at the start of a rune, noting how
+// each piece starts so a word across a split is one word; and for -m, -L and
+// Unicode words, just after a newline, since the width of a line depends on
+// all of it and a word boundary can depend on what's around it.
+func countChunks(opts Options, f io.ReaderAt, start, end int64, n int) (Results, error) {
+	runes := opts.GetBool(LongFlag) || opts.GetBool(CharFlag) && !opts.SingleByte ||
+		opts.Mode == ModeUnicode && opts.GetBool(WordFlag)
+	words := !runes && opts.GetBool(WordFlag)
+	c := classesFor(opts.Mode)
+	align := func(f io.ReaderAt, at, end int64) int64 { return at }
+	switch {
+	case runes:
+		align = afterNewline
+	case words:
+		align = runeStart
+	}
+
+	bounds := []int64{start}
+	for i := 1; i < n; i++ {
+		at := align(f, start+(end-start)*int64(i)/int64(n), end)
+		if at > bounds[len(bounds)-1] && at < end {
+			bounds = append(bounds, at)
+		}
+	}
+	bounds = append(bounds, end)
+
+	pieces := len(bounds) - 1
+	parts := make([]Results, pieces)
+	// for words: if each piece ends in a word, and the first rune in it
+	// that starts or ends one
+	inWords := make([]uint, pieces)
+	firsts := make([]uint8, pieces)
+	errs := make([]error, pieces)
+	wg := sync.WaitGroup{}
+	for i := 0; i < pieces; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			section := io.NewSectionReader(f, bounds[i], bounds[i+1]-bounds[i])
+			if !words {
+				parts[i], errs[i] = WordCount(opts, section)
+				return
+			}
+			if parts[i], inWords[i], errs[i] = countWordsFrom(section, c, opts.SingleByte); errs[i] == nil {
+				firsts[i], errs[i] = firstWordRune(io.NewSectionReader(f, bounds[i], bounds[i+1]-bounds[i]), c, opts.SingleByte)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	results := Results{}
+	var inWord uint
+	for i, part := range parts {
+		if errs[i] != nil {
+			return results, errs[i]
+		}
+		if words {
+			switch firsts[i] {
+			case space:
+				part.Words += inWord
+			case 0:
+				inWords[i] = inWord
+			}
+			inWord = inWords[i]
+		}
+		results.Add(part)
+	}
+	results.Words += inWord
+	return results, nil
+}
+
+// runeStart moves at forward past any UTF-8 continuation bytes, so a rune
+// isn't split between two pieces
+func runeStart(f io.ReaderAt, at, end int64) int64 {
+	buf := make([]byte, utf8.UTFMax-1)
+	n, _ := f.ReadAt(buf, at)
+	for _, c := range buf[:n] {
+		if utf8.RuneStart(c) {
+			break
+		}
+		at++
+	}
+	return at
+}
+
+// afterNewline moves at forward to just past the next newline, or to end if
+// there isn't one
+func afterNewline(f io.ReaderAt, at, end int64) int64 {
+	buf := make([]byte, 64<<10)
+	for at < end {
+		n, err := f.ReadAt(buf, at)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return at + int64(i) + 1
+		}
+		at += int64(n)
+		if err != nil {
+			break
+		}
+	}
+	return end
+}
+
+// firstWordRune is whether the first character in that starts or ends a
+// word is printable or a space, as countWords sees them, or 0 if none do
+func firstWordRune(in io.Reader, c *classes, singleByte bool) (uint8, error) {
+	buffered := bufio.NewReader(in)
+	for {
+		var r rune
+		var size int
+		var err error
+		if singleByte {
+			var b byte
+			b, err = buffered.ReadByte()
+			r = rune(b)
+			if b >= utf8.RuneSelf {
+				r = -1
+			}
+		} else if r, size, err = buffered.ReadRune(); r == utf8.RuneError && size == 1 {
+			r = -1
+		}
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if class := c.of(r) & (printable | space); class != 0 {
+			return class, nil
+		}
+	}
+}
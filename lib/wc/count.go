@@ -0,0 +1,127 @@
+package wc
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"unicode/utf8"
+)
+
+const countBufferSize = 128 << 10
+
+var countBuffers = sync.Pool{New: func() interface{} { return make([]byte, countBufferSize) }}
+
+// eachChunk reads in through to the end, a buffer at a time
+func eachChunk(in io.Reader, chunk func([]byte)) error {
+	buf := countBuffers.Get().([]byte)
+	defer countBuffers.Put(buf)
+	for {
+		n, err := in.Read(buf)
+		chunk(buf[:n])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// countBytes takes the size of a regular file from fstat rather than reading
+// it, the way GNU does: from wherever the reader is up to, then reading
+// whatever has been added since.
+func countBytes(in io.Reader) (Results, error) {
+	results := Results{}
+	if file, ok := in.(interface {
+		io.Seeker
+		Stat() (os.FileInfo, error)
+	}); ok {
+		info, err := file.Stat()
+		if err == nil && info.Mode().IsRegular() {
+			pos, err := file.Seek(0, io.SeekCurrent)
+			if err == nil && pos < info.Size() {
+				if _, err := file.Seek(info.Size(), io.SeekStart); err == nil {
+					results.Bytes = uint(info.Size() - pos)
+				}
+			}
+		}
+	}
+	err := eachChunk(in, func(chunk []byte) {
+		results.Bytes += uint(len(chunk))
+	})
+	return results, err
+}
+
+// countLines is newlines and bytes
+func countLines(in io.Reader) (Results, error) {
+	results := Results{}
+	err := eachChunk(in, func(chunk []byte) {
+		results.Bytes += uint(len(chunk))
+		results.Newlines += uint(bytes.Count(chunk, []byte{'\n'}))
+	})
+	return results, err
+}
+
+// countWords is words, newlines and bytes.  ASCII is looked up a byte at a
+// time; only the rest has to be decoded, and not even that in a single byte
+// locale.
+func countWords(in io.Reader, c *classes, singleByte bool) (Results, error) {
+	results, inWord, err := countWordsFrom(in, c, singleByte)
+	results.Words += inWord
+	return results, err
+}
+
+// countWordsFrom is countWords, leaving out the word in is still in at the
+// end, so a piece of a file can be counted on its own; inWord is 1 if it is.
+func countWordsFrom(in io.Reader, c *classes, singleByte bool) (Results, uint, error) {
+	buf := countBuffers.Get().([]byte)
+	defer countBuffers.Put(buf)
+	var counted, lines, words, inWord uint
+	// carry is the start of a rune split across two reads
+	carry := 0
+	for {
+		n, err := in.Read(buf[carry:])
+		data := buf[:carry+n]
+		carry = 0
+		i := 0
+		for i < len(data) {
+			for ; i < len(data) && data[i] < utf8.RuneSelf; i++ {
+				class := uint(c.ascii[data[i]])
+				isSpace := class & space >> 1
+				lines += class >> 2
+				words += inWord & isSpace
+				inWord = inWord&^isSpace | class&printable
+			}
+			if i == len(data) {
+				break
+			}
+			r, size := rune(-1), 1
+			if !singleByte {
+				if err == nil && !utf8.FullRune(data[i:]) {
+					carry = copy(buf, data[i:])
+					break
+				}
+				if r, size = utf8.DecodeRune(data[i:]); r == utf8.RuneError && size == 1 {
+					r = -1
+				}
+			}
+			switch c.of(r) {
+			case space:
+				words += inWord
+				inWord = 0
+			case printable:
+				inWord = 1
+			}
+			i += size
+		}
+		counted += uint(i)
+		if err != nil {
+			results := Results{Bytes: counted, Newlines: lines, Words: words}
+			if err == io.EOF {
+				err = nil
+			}
+			return results, inWord, err
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package wc
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+const FormatFlag = "format"
+
+// Format is how the counts are printed
+type Format string
+
+const (
+	// FormatText is the space padded columns GNU prints; the default
+	FormatText Format = "text"
+	// FormatJSON is one JSON object per line
+	FormatJSON Format = "json"
+	FormatCSV  Format = "csv"
+	// FormatTSV is tab separated, with tabs, newlines, carriage returns and
+	// backslashes in a field escaped as \t, \n, \r and \\
+	FormatTSV Format = "tsv"
+	// FormatNUL has a NUL after every field, header included, and nothing
+	// escaped, so filenames come through as they are.  A row is as many
+	// fields as the header has.
+	FormatNUL Format = "nul"
+)
+
+func (f *Format) String() string {
+	if *f == "" {
+		return string(FormatText)
+	}
+	return string(*f)
+}
+
+func (f *Format) Set(value string) error {
+	switch format := Format(value); format {
+	case FormatText, FormatJSON, FormatCSV, FormatTSV, FormatNUL:
+		*f = format
+		return nil
+	}
+	return errors.New("valid arguments are text, json, csv, tsv, nul")
+}
+
+func (f *Format) Type() string { return "FORMAT" }
+
+// nulFlag is -0, which is --format=nul
+type nulFlag struct {
+	format *Format
+}
+
+func (n nulFlag) String() string { return "false" }
+func (n nulFlag) Type() string   { return "bool" }
+
+func (n nulFlag) Set(value string) error {
+	if value == "true" {
+		*n.format = FormatNUL
+	}
+	return nil
+}
+
+// record is one row of the machine readable formats.  The counts are only
+// there when they were selected and the file could be opened; the total is
+// the only row with neither a filename nor an error.
+type record struct {
+	Lines    *uint  `json:"lines,omitempty"`
+	Words    *uint  `json:"words,omitempty"`
+	Chars    *uint  `json:"chars,omitempty"`
+	Bytes    *uint  `json:"bytes,omitempty"`
+	Longest  *uint  `json:"max-line-length,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// keys are the machine readable names of the counts, in the order they're
+// printed
+var keys = []struct{ flag, key string }{
+	{LineFlag, "lines"},
+	{WordFlag, "words"},
+	{CharFlag, "chars"},
+	{ByteFlag, "bytes"},
+	{LongFlag, "max-line-length"},
+}
+
+// header is the keys of the selected counts and the rest of a record
+func header(options Options) []string {
+	var header []string
+	for _, k := range keys {
+		if options.GetBool(k.flag) {
+			header = append(header, k.key)
+		}
+	}
+	return append(header, "filename", "error")
+}
+
+func newRecord(options Options, r Results, counted bool) record {
+	rec := record{Filename: r.Filename}
+	if r.Err != nil {
+		var e *lib.Error
+		if errors.As(r.Err, &e) {
+			rec.Error = e.Reason()
+		} else {
+			rec.Error = r.Err.Error()
+		}
+	}
+	if !counted {
+		return rec
+	}
+	count := func(flag string, n uint) *uint {
+		if !options.GetBool(flag) {
+			return nil
+		}
+		return &n
+	}
+	rec.Lines = count(LineFlag, r.Newlines)
+	rec.Words = count(WordFlag, r.Words)
+	rec.Chars = count(CharFlag, r.Characters)
+	rec.Bytes = count(ByteFlag, r.Bytes)
+	rec.Longest = count(LongFlag, r.Longest)
+	return rec
+}
+
+// fields are rec's values for the header, empty where there is no count
+func (rec record) fields(options Options) []string {
+	var fields []string
+	for i, n := range []*uint{rec.Lines, rec.Words, rec.Chars, rec.Bytes, rec.Longest} {
+		switch {
+		case !options.GetBool(keys[i].flag):
+		case n == nil:
+			fields = append(fields, "")
+		default:
+			fields = append(fields, strconv.FormatUint(uint64(*n), 10))
+		}
+	}
+	return append(fields, rec.Filename, rec.Error)
+}
+
+var tsvEscaper = strings.NewReplacer("\\", `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// printer prints each file's results as they come, then the total
+type printer struct {
+	w       io.Writer
+	options Options
+	width   int
+	// named is if the files were named, rather than just reading stdin
+	named bool
+
+	header []string
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+// newPrinter for options.Format, which starts with the header if it has one
+func newPrinter(w io.Writer, options Options, width int, named bool) *printer {
+	p := &printer{w: w, options: options, width: width, named: named, header: header(options)}
+	switch options.Format {
+	case FormatJSON:
+		p.json = json.NewEncoder(w)
+		p.json.SetEscapeHTML(false)
+	case FormatCSV:
+		p.csv = csv.NewWriter(w)
+		p.csv.Write(p.header)
+		p.csv.Flush()
+	case FormatTSV:
+		fmt.Fprintln(w, strings.Join(p.header, "\t"))
+	case FormatNUL:
+		fmt.Fprint(w, nulFields(p.header))
+	}
+	return p
+}
+
+// file prints a file's results.  The text format leaves out files that
+// couldn't be opened; the others print them with just the error.
+func (p *printer) file(r Results) {
+	switch p.options.Format {
+	case FormatJSON, FormatCSV, FormatTSV, FormatNUL:
+		p.record(newRecord(p.options, r, opened(r)))
+	default:
+		if !opened(r) {
+			return
+		}
+		if !p.named {
+			r.Filename = ""
+		}
+		fmt.Fprint(p.w, r.Row(p.options, p.width))
+	}
+}
+
+// total prints the total line
+func (p *printer) total(r Results) {
+	switch p.options.Format {
+	case FormatJSON, FormatCSV, FormatTSV, FormatNUL:
+		r.Filename = ""
+		p.record(newRecord(p.options, r, true))
+	default:
+		r.Filename = "total"
+		if p.options.Total == TotalOnly {
+			r.Filename = ""
+		}
+		fmt.Fprint(p.w, r.Row(p.options, p.width))
+	}
+}
+
+func (p *printer) record(rec record) {
+	switch p.options.Format {
+	case FormatJSON:
+		p.json.Encode(rec)
+	case FormatCSV:
+		p.csv.Write(rec.fields(p.options))
+		p.csv.Flush()
+	case FormatTSV:
+		fields := rec.fields(p.options)
+		for i := range fields {
+			fields[i] = tsvEscaper.Replace(fields[i])
+		}
+		fmt.Fprintln(p.w, strings.Join(fields, "\t"))
+	case FormatNUL:
+		fmt.Fprint(p.w, nulFields(rec.fields(p.options)))
+	}
+}
+
+// nulFields is fields each ending in a NUL
+func nulFields(fields []string) string {
+	return strings.Join(fields, "\x00") + "\x00"
+}
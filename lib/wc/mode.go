@@ -0,0 +1,152 @@
+package wc
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+)
+
+const (
+	PosixFlag   = "posix"
+	UnicodeFlag = "unicode"
+)
+
+// Mode is what wc takes a word to be.  In every mode only \n ends a line,
+// and -m counts the characters of the locale: every byte is one in the C
+// locale, while in a UTF-8 locale bytes that aren't UTF-8 aren't characters
+// at all.
+type Mode int
+
+const (
+	// ModeGNU is what GNU wc does, and the default: a word is between white
+	// space, and has at least one printable character in it.  The no-break
+	// spaces count as white space.
+	ModeGNU Mode = iota
+	// ModePOSIX is a word as POSIX has it: anything at all between white
+	// space, control characters and bytes that aren't characters included.
+	// The no-break spaces aren't white space.
+	ModePOSIX
+	// ModeUnicode takes words from the Unicode word boundaries (UAX #29)
+	// rather than white space, and a word is any piece with a letter or a
+	// number in it.  It always reads UTF-8.  Each CJK ideograph is a word;
+	// so is each letter, with its marks, of scripts like Thai that need a
+	// dictionary to split, since the rules have nothing else to go by.
+	ModeUnicode
+)
+
+// modeFlag is a boolean flag that sets the mode
+type modeFlag struct {
+	mode  *Mode
+	value Mode
+}
+
+func (m modeFlag) String() string { return "false" }
+func (m modeFlag) Type() string   { return "bool" }
+
+func (m modeFlag) Set(value string) error {
+	if value == "true" {
+		*m.mode = m.value
+	}
+	return nil
+}
+
+// SingleByte reports if the locale in ctx's environment, from LC_ALL,
+// LC_CTYPE or LANG, has a character to every byte: anything but UTF-8, the
+// C locale included, which is what no locale at all means.
+func SingleByte(ctx *lib.Context) bool {
+	locale := ""
+	for _, key := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale = ctx.Getenv(key); locale != "" {
+			break
+		}
+	}
+	locale = strings.ToLower(locale)
+	return !strings.Contains(locale, "utf-8") && !strings.Contains(locale, "utf8")
+}
+
+// What each mode makes of each character, as bits so the ASCII fast path
+// can count without branching: a newline is a space too, and a character
+// that is neither can't start or end a word.
+const (
+	printable = 1 << iota
+	space
+	newline
+)
+
+// classes are what a mode makes of each character
+type classes struct {
+	ascii [utf8.RuneSelf]uint8
+	// high is a byte that isn't a character: one that isn't UTF-8, or any
+	// past ASCII in a single byte locale
+	high uint8
+	// class of a rune past ASCII
+	class func(rune) uint8
+}
+
+var (
+	gnuClasses   = newClasses(ModeGNU)
+	posixClasses = newClasses(ModePOSIX)
+)
+
+// classesFor the mode; ModeUnicode counts words its own way, and leaves
+// the rest to ModeGNU's
+func classesFor(mode Mode) *classes {
+	if mode == ModePOSIX {
+		return posixClasses
+	}
+	return gnuClasses
+}
+
+func newClasses(mode Mode) *classes {
+	c := &classes{class: gnuClass}
+	if mode == ModePOSIX {
+		c.high = printable
+		c.class = posixClass
+	}
+	for b := range c.ascii {
+		c.ascii[b] = c.class(rune(b))
+	}
+	c.ascii['\n'] |= newline
+	return c
+}
+
+// isSpace is white space as the C library has it, which unlike
+// unicode.IsSpace leaves out NEL and the no-break spaces
+func isSpace(r rune) bool {
+	switch r {
+	case '\u0085', '\u00a0', '\u2007', '\u202f':
+		return false
+	}
+	return unicode.IsSpace(r)
+}
+
+func gnuClass(r rune) uint8 {
+	switch {
+	case isSpace(r), r == '\u00a0', r == '\u2007', r == '\u202f', r == '\u2060':
+		return space
+	case unicode.IsPrint(r):
+		return printable
+	}
+	return 0
+}
+
+func posixClass(r rune) uint8 {
+	if isSpace(r) {
+		return space
+	}
+	return printable
+}
+
+// of is the class of the rune r, or of a byte that isn't a character if r is
+// negative
+func (c *classes) of(r rune) uint8 {
+	switch {
+	case r < 0:
+		return c.high
+	case r < utf8.RuneSelf:
+		return c.ascii[r]
+	}
+	return c.class(r)
+}
@@ -0,0 +1,107 @@
+package wc
+
+import (
+	"bytes"
+	"io"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// countSegments counts everything in ModeUnicode, taking the words from the
+// Unicode word boundaries; golang.org/x/text doesn't find those, so uniseg
+// does.
+func countSegments(opts Options, in io.Reader) (Results, error) {
+	c := newRuneCounter(opts, nil)
+	err := eachSegment(in, 2, func(b []byte, state int) (piece, rest []byte, width, newState int) {
+		piece, rest, newState = uniseg.FirstWord(b, state)
+		return piece, rest, 0, newState
+	}, func(piece []byte, _ int) { c.addPiece(piece) })
+	return c.end(), err
+}
+
+// eachSegment reads in through to the end and calls add with each piece
+// next splits it into, with the width next gives it.  Where a piece ends can
+// depend on what comes after it, so the last keep pieces of each read, one
+// at least, wait for the next one, along with any rune the read cut short.
+func eachSegment(in io.Reader, keep int,
+	next func(b []byte, state int) (piece, rest []byte, width, newState int),
+	add func(piece []byte, width int)) error {
+	type pending struct {
+		piece []byte
+		width int
+	}
+	waiting := make([]pending, keep)
+	buf := make([]byte, countBufferSize)
+	carry := 0
+	for {
+		if carry == len(buf) {
+			// pieces as big as the buffer; make room for the rest of them
+			buf = append(buf, make([]byte, len(buf))...)
+		}
+		n, err := io.ReadFull(in, buf[carry:])
+		data := buf[:carry+n]
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+
+		whole := data
+		if !eof {
+			whole = data[:fullRunes(data)]
+		}
+		counted := 0
+		state := -1
+		for i, rest := 0, whole; len(rest) > 0; i++ {
+			var piece []byte
+			var width int
+			piece, rest, width, state = next(rest, state)
+			if eof {
+				add(piece, width)
+				continue
+			}
+			// a ring of the pieces still waiting; the oldest goes now
+			slot := &waiting[i%len(waiting)]
+			if i >= keep {
+				add(slot.piece, slot.width)
+				counted += len(slot.piece)
+			}
+			*slot = pending{piece, width}
+		}
+		carry = copy(buf, data[counted:])
+
+		if eof {
+			return nil
+		}
+	}
+}
+
+// fullRunes is how much of b there is before a rune cut short at its end
+func fullRunes(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
+
+// addPiece between two word boundaries, which is a word if it has a letter
+// or a number in it.  Words are split between grapheme clusters, never in
+// the middle of one.
+func (c *runeCounter) addPiece(piece []byte) {
+	if bytes.IndexFunc(piece, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) >= 0 {
+		c.Words++
+	}
+	state := -1
+	for len(piece) > 0 {
+		var cluster []byte
+		var width int
+		cluster, piece, width, state = uniseg.FirstGraphemeCluster(piece, state)
+		c.addCluster(cluster, width)
+	}
+}
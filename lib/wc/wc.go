@@ -5,9 +5,10 @@ import (
 	"fmt"
 	"io"
 	"strings"
-	"unicode"
+	"sync"
+	"unicode/utf8"
 
-	"golang.org/x/text/width"
+	"github.com/rivo/uniseg"
 )
 
 const order = "lwmcL"
@@ -18,13 +19,101 @@ const (
 	WordFlag = "words"
 	CharFlag = "chars"
 	LongFlag = "max-line-length"
+
+	TotalFlag  = "total"
+	Files0Flag = "files0-from"
+	JobsFlag   = "jobs"
+	TabFlag    = "tabsize"
+)
+
+// DefaultTabSize is where tab stops are unless --tabsize says otherwise
+const DefaultTabSize = 8
+
+// TotalMode is when to print the total line
+type TotalMode string
+
+const (
+	// TotalAuto prints it when there is more than one file; the default
+	TotalAuto   TotalMode = "auto"
+	TotalAlways TotalMode = "always"
+	// TotalOnly prints just the total counts, without the word total
+	TotalOnly  TotalMode = "only"
+	TotalNever TotalMode = "never"
 )
 
+func (t *TotalMode) String() string {
+	if *t == "" {
+		return string(TotalAuto)
+	}
+	return string(*t)
+}
+
+func (t *TotalMode) Set(value string) error {
+	switch mode := TotalMode(value); mode {
+	case TotalAuto, TotalAlways, TotalOnly, TotalNever:
+		*t = mode
+		return nil
+	}
+	return fmt.Errorf("invalid argument %q; valid arguments are auto, always, only, never", value)
+}
+
+func (t *TotalMode) Type() string { return "WHEN" }
+
+// print reports if the total line is printed for this many files
+func (t TotalMode) print(files int) bool {
+	switch t {
+	case TotalAlways, TotalOnly:
+		return true
+	case TotalNever:
+		return false
+	default:
+		return files > 1
+	}
+}
+
 type Options struct {
 	Bytes, Characters, Newlines, Words, Longest bool
+	Total                                       TotalMode
+	Format                                      Format
 
 	NFlag uint
 	Files []string
+	// Files0From is a file of NUL terminated names to count instead of
+	// Files; "-" is standard input
+	Files0From string
+
+	// Mode is what a word is
+	Mode Mode
+	// SingleByte is a locale with a character to every byte, rather than
+	// UTF-8; see SingleByte
+	SingleByte bool
+
+	// Jobs is how many files, or pieces of a big file, are counted at once;
+	// 0 is as many as there are CPUs
+	Jobs int
+
+	// Decompress counts what's in compressed files; see lib/decompress
+	Decompress bool
+
+	// TabSize is how many columns apart -L takes tab stops to be; 0 is
+	// DefaultTabSize
+	TabSize int
+
+	// stdinLock stops two workers reading standard input at once
+	stdinLock *sync.Mutex
+	// chunks is how many pieces each worker splits a big file into
+	chunks int
+}
+
+// selected is how many counts are printed
+func (wo Options) selected() int {
+	n := 0
+	for _, flag := range []string{LineFlag, WordFlag, CharFlag, ByteFlag, LongFlag} {
+		if wo.GetBool(flag) {
+			n++
+		}
+	}
+	return n
 }
 
 func (wo Options) GetBool(flag string) bool {
@@ -49,12 +138,54 @@ type Results struct {
 	Bytes, Characters, Newlines, Words, Longest uint
 
 	Filename string
+	// Err is why the file couldn't be counted, or counted all the way
+	Err error
 }
 
-type ResultsSet struct {
-	MaxNumber uint
+// Add other's counts to r, for a total
+func (r *Results) Add(other Results) {
+	r.Bytes += other.Bytes
+	r.Characters += other.Characters
+	r.Newlines += other.Newlines
+	r.Words += other.Words
+	if other.Longest > r.Longest {
+		r.Longest = other.Longest
+	}
+}
 
-	Results []Results
+// Row is the line GNU prints for r: the selected counts right aligned to
+// width, separated by single spaces, then the filename if there is one
+func (r Results) Row(options Options, width int) string {
+	builder := strings.Builder{}
+	for _, c := range order {
+		var flag string
+		var count uint
+		switch c {
+		case 'l':
+			flag, count = LineFlag, r.Newlines
+		case 'w':
+			flag, count = WordFlag, r.Words
+		case 'm':
+			flag, count = CharFlag, r.Characters
+		case 'c':
+			flag, count = ByteFlag, r.Bytes
+		case 'L':
+			flag, count = LongFlag, r.Longest
+		}
+		if !options.GetBool(flag) {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteByte(' ')
+		}
+		fmt.Fprintf(&builder, "%*d", width, count)
+	}
+	if r.Filename != "" {
+		builder.WriteByte(' ')
+		builder.WriteString(r.Filename)
+	}
+	builder.WriteByte('\n')
+	return builder.String()
 }
 
 func approxLog10(u uint) uint {
@@ -68,167 +199,178 @@ func approxLog10(u uint) uint {
 	return 1
 }
 
-// GetMax number (that isn't line) from this resultset for use in max line length parameter
-func (r Results) GetMax(options Options) (max uint) {
-	for _, c := range order[:4] {
-		switch c {
-		case 'l':
-			if !options.GetBool(LineFlag) {
-				break
-			}
-			if max < r.Newlines {
-				max = r.Newlines
-			}
-		case 'w':
-			if !options.GetBool(WordFlag) {
-				break
-			}
-			if max < r.Words {
-				max = r.Words
-			}
-		case 'm':
-			if !options.GetBool(CharFlag) {
-				break
-			}
-			if max < r.Characters {
-				max = r.Characters
-			}
-		case 'c':
-			if !options.GetBool(ByteFlag) {
-				break
-			}
-			if max < r.Bytes {
-				max = r.Bytes
-			}
-		}
+// WordCount counts what opts selects from in, the fastest way it can; the
+// counts that aren't selected are left 0.  Only -m and -L need every rune
+// decoded, and -m not even then in a single byte locale.
+func WordCount(opts Options, in io.Reader) (Results, error) {
+	var results Results
+	var err error
+	switch {
+	case opts.Mode == ModeUnicode && opts.GetBool(WordFlag):
+		return countSegments(opts, in)
+	case opts.GetBool(LongFlag), opts.GetBool(CharFlag) && !opts.SingleByte:
+		return countRunes(opts, in)
+	case opts.GetBool(WordFlag):
+		results, err = countWords(in, classesFor(opts.Mode), opts.SingleByte)
+	case opts.GetBool(LineFlag):
+		results, err = countLines(in)
+	default:
+		results, err = countBytes(in)
 	}
-	return
+	if opts.GetBool(CharFlag) {
+		results.Characters = results.Bytes
+	}
+	return results, err
 }
 
-// Printf prints all results based on format options
-func (rs ResultsSet) Printf(options Options) string {
-	builder := strings.Builder{}
-	width := approxLog10(rs.MaxNumber) + 2
-	fmtstring := fmt.Sprintf("%%%dd", width)
-	for _, results := range rs.Results {
-	loop:
-		for _, c := range order {
-			switch c {
-			case 'l':
-				if !options.GetBool(LineFlag) {
-					continue loop
-				}
-				builder.WriteString(fmt.Sprintf(fmtstring, results.Newlines))
-			case 'w':
-				if !options.GetBool(WordFlag) {
-					continue loop
-				}
-				builder.WriteString(fmt.Sprintf(fmtstring, results.Words))
-			case 'm':
-				if !options.GetBool(CharFlag) {
-					continue loop
-				}
-				builder.WriteString(fmt.Sprintf(fmtstring, results.Characters))
-			case 'c':
-				if !options.GetBool(ByteFlag) {
-					continue loop
-				}
-				builder.WriteString(fmt.Sprintf(fmtstring, results.Bytes))
-			case 'L':
-				if !options.GetBool(LongFlag) {
-					continue loop
-				}
-				builder.WriteString(fmt.Sprintf(fmtstring, results.Longest))
-			}
+// runeCounter counts a rune at a time, for when -m or -L need every rune
+// decoded
+type runeCounter struct {
+	Results
+	// classes are what makes a word, or nil to leave words to the caller
+	classes          *classes
+	inWord, position uint
+	// tab is how far apart tab stops are
+	tab uint
+}
 
+func newRuneCounter(opts Options, c *classes) runeCounter {
+	tab := uint(opts.TabSize)
+	if tab == 0 {
+		tab = DefaultTabSize
+	}
+	return runeCounter{classes: c, tab: tab}
+}
+
+// add r, which took size bytes.  r is negative for a byte that isn't a
+// character of its own in the locale, and char is if it counts as one
+// anyway.  The width of the line is left to column.
+func (c *runeCounter) add(r rune, size int, char bool) {
+	c.Bytes += uint(size)
+	if char {
+		c.Characters++
+	}
+	if c.classes != nil {
+		switch class := c.classes.of(r); {
+		case class&space != 0:
+			c.Words += c.inWord
+			c.inWord = 0
+		case class&printable != 0:
+			c.inWord = 1
 		}
-		if results.Filename != "" {
-			builder.WriteString("  ")
-			builder.WriteString(results.Filename)
+	}
+	if r == '\n' {
+		c.Newlines++
+	}
+}
+
+// column moves along the line past something width columns wide that
+// starts with first.  \n, \r and \f end the line, as they do for GNU, and
+// a tab goes to the next tab stop.
+func (c *runeCounter) column(first rune, width int) {
+	switch first {
+	case '\t':
+		c.position += c.tab - c.position%c.tab
+	case '\n', '\r', '\f':
+		c.endLine()
+	default:
+		c.position += uint(width)
+	}
+}
+
+// addCluster adds a grapheme cluster of width columns: what a terminal
+// shows as one character, like a letter and its accents, or an emoji
+// sequence.  A byte that isn't UTF-8 is a cluster of its own and takes no
+// room at all.
+func (c *runeCounter) addCluster(cluster []byte, width int) {
+	first, _ := utf8.DecodeRune(cluster)
+	if first == '\u00ad' {
+		// a soft hyphen is a hyphen to a terminal, and to the C library
+		width = 1
+	}
+	for b := cluster; len(b) > 0; {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			c.add(-1, 1, false)
+			first, width = -1, 0
+		} else {
+			c.add(r, size, true)
 		}
-		builder.WriteRune('\n')
+		b = b[size:]
 	}
-	return builder.String()
+	c.column(first, width)
 }
 
-// Default layout
-func (rs ResultsSet) String() string {
-	builder := strings.Builder{}
-	width := approxLog10(rs.MaxNumber) + 2
-	fmtstring := fmt.Sprintf("%%%dd%%%dd%%%dd  %%s", width, width, width)
-	for _, result := range rs.Results {
-		builder.WriteString(fmt.Sprintf(fmtstring, result.Newlines, result.Words, result.Bytes, result.Filename))
-		builder.WriteRune('\n')
+// nextCluster is uniseg.FirstGraphemeCluster, only quicker over ASCII: a
+// byte with another after it is a cluster of its own, as nothing in ASCII
+// joins on to the one before but \n after \r, which ends the line all the
+// same.
+func nextCluster(b []byte, state int) (cluster, rest []byte, width, newState int) {
+	if len(b) > 1 && b[0] < utf8.RuneSelf && b[1] < utf8.RuneSelf {
+		if b[0] >= ' ' && b[0] < 0x7f {
+			width = 1
+		}
+		return b[:1], b[1:], width, -1
 	}
-	return builder.String()
+	return uniseg.FirstGraphemeCluster(b, state)
 }
 
-func runeSize(r rune) uint {
-	switch width.LookupRune(r).Kind() {
-	case width.EastAsianNarrow:
-		return 1
-	case width.EastAsianFullwidth, width.EastAsianWide:
-		return 2
-	default:
-		return 1
+func (c *runeCounter) endLine() {
+	if c.position > c.Longest {
+		c.Longest = c.position
 	}
+	c.position = 0
 }
 
-func WordCount(opts Options, in io.Reader) (Results, error) {
-	buffered := bufio.NewReader(in)
-	results := Results{}
-	var inWord, position uint
+// end of the input; the counts
+func (c *runeCounter) end() Results {
+	c.Words += c.inWord
+	c.inWord = 0
+	c.endLine()
+	return c.Results
+}
 
+// countRunes counts everything, a rune at a time, or a grapheme cluster at
+// a time for -L in a UTF-8 locale.  In a single byte locale each byte is
+// one column wide if it's printable ASCII and none if it isn't.
+func countRunes(opts Options, in io.Reader) (Results, error) {
+	c := newRuneCounter(opts, classesFor(opts.Mode))
+	if !opts.SingleByte && opts.GetBool(LongFlag) {
+		err := eachSegment(in, 1, nextCluster, c.addCluster)
+		return c.end(), err
+	}
+	buffered := bufio.NewReader(in)
 	for {
-		r, size, err := buffered.ReadRune()
-		if err != nil {
-			if err == io.EOF {
-				results.Words += inWord
-				if position > results.Longest {
-					results.Longest = position
-				}
-				return results, nil
+		var r rune
+		var size int
+		var err error
+		if opts.SingleByte {
+			var b byte
+			b, err = buffered.ReadByte()
+			r, size = rune(b), 1
+			if b >= utf8.RuneSelf {
+				r = -1
 			}
-			return results, err
+		} else {
+			r, size, err = buffered.ReadRune()
 		}
-		if size > 0 {
-			results.Characters++
-			results.Bytes += uint(size)
+		if err == io.EOF {
+			return c.end(), nil
+		}
+		if err != nil {
+			return c.Results, err
 		}
-		if unicode.IsPrint(r) && !unicode.IsSpace(r) {
-			inWord = 1
-			position += runeSize(r)
+		if r == utf8.RuneError && size == 1 {
+			c.add(-1, 1, false)
 			continue
 		}
-		if unicode.IsSpace(r) {
-			results.Words += inWord
-			inWord = 0
-			switch r {
-			case '\t':
-				// round up to 8 ( set LSBs to 111, then add one)
-				position = (position | 7) + 1
-			case '\v':
-				results.Newlines++
-			case '\u00A0', ' ':
-				position += runeSize(r)
-				continue
-			case '\n':
-				results.Newlines++
-				if position > results.Longest {
-					results.Longest = position
-				}
-				position = 0
-			case '\r', '\u0085':
-				if position > results.Longest {
-					results.Longest = position
-				}
-				position = 0
-			case '\f':
-				if position > results.Longest {
-					results.Longest = position
-				}
-				position = 0
+		c.add(r, size, true)
+		if opts.SingleByte {
+			width := 0
+			if r >= ' ' && r < 0x7f {
+				width = 1
 			}
+			c.column(r, width)
 		}
 	}
 }
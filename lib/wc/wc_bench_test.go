@@ -0,0 +1,56 @@
+package wc_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"gitlab.com/yarbelk/slimbox/lib"
+	"gitlab.com/yarbelk/slimbox/lib/internal/corpus"
+	"gitlab.com/yarbelk/slimbox/lib/wc"
+)
+
+var benchOptions = []struct {
+	name    string
+	options wc.Options
+}{
+	{"default", wc.Options{}},
+	{"-l", wc.Options{Newlines: true, NFlag: 1}},
+	{"-c", wc.Options{Bytes: true, NFlag: 1}},
+	{"-w", wc.Options{Words: true, NFlag: 1}},
+	{"-mL", wc.Options{Characters: true, Longest: true, NFlag: 2}},
+}
+
+func BenchmarkWordCount(b *testing.B) {
+	for _, c := range corpus.All() {
+		for _, o := range benchOptions {
+			c, o := c, o
+			b.Run(c.Name+"/"+o.name, func(b *testing.B) {
+				b.SetBytes(int64(len(c.Data)))
+				for i := 0; i < b.N; i++ {
+					if _, err := wc.WordCount(o.options, bytes.NewReader(c.Data)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkMain(b *testing.B) {
+	dir, names, size := corpus.SmallFiles(b, 1000)
+	ctx := &lib.Context{Stdout: io.Discard, Stderr: io.Discard, Dir: dir}
+	for _, o := range benchOptions {
+		o := o
+		b.Run("small-files/"+o.name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				options := o.options
+				options.Files = names
+				if err := wc.Main(ctx, options); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,63 +1,361 @@
 package wc
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"runtime"
 	"strings"
 	"sync"
 
 	"github.com/spf13/pflag"
 	"gitlab.com/yarbelk/slimbox/lib"
+	"gitlab.com/yarbelk/slimbox/lib/decompress"
 )
 
 func init() {
-	lib.RegisterFunction("wc")
+	lib.RegisterApplet(func() lib.Applet { return &applet{} })
 }
 
-type errors []error
+type applet struct {
+	options Options
+	fs      *pflag.FlagSet
+}
+
+func (a *applet) Name() string    { return "wc" }
+func (a *applet) Summary() string { return "print newline, word, and byte counts for each file" }
+
+func (a *applet) FlagSet() *pflag.FlagSet {
+	a.fs = BindFlagSet(&a.options)
+	return a.fs
+}
+
+func (a *applet) Usage() lib.Usage {
+	return lib.Usage{
+		Operands: "[FILE]...",
+		Description: `Print newline, word, and byte counts for each FILE, and a total line if
+more than one FILE is specified.  A word is a non-zero-length sequence of
+characters delimited by white space.
+
+With no FILE, or when FILE is -, read standard input.  - can appear multiple
+times in the list, and standard input will be read for each.
+
+With --files0-from, the FILEs are the NUL terminated names in F instead, such
+as find -print0 writes.  They are counted as they are read, so the list can
+be as long as you like.
+
+wc counts --jobs files at once, as many as there are CPUs in your system
+unless you say otherwise, and splits big regular files between the jobs no
+file needs.  Standard input is read by one at a time.  Each file's counts
+are printed as soon as it and every file before it are done.
+
+Lines are counted by \n alone.  Characters are as LC_ALL, LC_CTYPE or LANG
+say: every byte in the C locale, and in a UTF-8 one, every character but
+the bytes that aren't UTF-8.  A word is white space delimited and has a
+printable character in it, as GNU wc has it; with --posix it's anything
+between white space, and with --unicode it's what's between the Unicode word
+boundaries and has a letter or number in it, so each CJK ideograph is a
+word.
+
+With -z, files compressed with gzip, bzip2, zlib, xz or zstd are counted
+as what's in them, so wc -lz logs/* needs no zcat, and any others as they
+are.
 
-func (es errors) Error() string {
-	builder := strings.Builder{}
-	for _, e := range es {
-		builder.WriteString(e.Error())
-		builder.WriteRune('\n')
+The maximum line length is how many columns the widest line takes on a
+terminal: East Asian wide characters take two, combining marks and other
+zero width characters none, and an emoji sequence or a letter with its
+accents is measured as one.  Tabs go to the next stop --tabsize apart, and
+\r and \f start the line again, as they do for GNU wc.
+
+The options below may be used to select which counts are printed, always in
+the following order: newline, word, character, byte, maximum line length.
+
+--format=json, csv, tsv or nul print the counts for scripts instead: a
+header (but for json), then a row per file with the keys lines, words,
+chars, bytes, max-line-length, filename and error, leaving out counts that
+weren't selected.  Files that couldn't be read have their error, and the
+total is the row with neither a filename nor an error.  nul, or -0, ends
+every field in a NUL, with nothing escaped, so a row is as many fields as
+the header has: xargs -0 -n with that many, and the like.`,
+	}
+}
+
+func (a *applet) Run(ctx *lib.Context, args []string) int {
+	for _, flag := range []string{LineFlag, ByteFlag, WordFlag, CharFlag, LongFlag} {
+		if a.fs.Changed(flag) {
+			a.options.NFlag++
+		}
+	}
+	if a.options.Files0From != "" && len(args) > 0 {
+		fmt.Fprintf(ctx.Stderr, "wc: extra operand '%s'\nfile operands cannot be combined with --files0-from\n", args[0])
+		fmt.Fprintln(ctx.Stderr, "Try 'wc --help' for more information.")
+		return lib.ExitFailure
+	}
+	if a.options.Jobs < 0 {
+		status := lib.Report(ctx.Stderr, lib.UsageError("wc", fmt.Errorf("invalid number of jobs: %d", a.options.Jobs)))
+		fmt.Fprintln(ctx.Stderr, "Try 'wc --help' for more information.")
+		return status
+	}
+	if a.options.TabSize < 1 {
+		status := lib.Report(ctx.Stderr, lib.UsageError("wc", fmt.Errorf("invalid tab size: %d", a.options.TabSize)))
+		fmt.Fprintln(ctx.Stderr, "Try 'wc --help' for more information.")
+		return status
 	}
-	return builder.String()
+	a.options.SingleByte = SingleByte(ctx)
+	a.options.Files = args
+	return lib.ExitCode(Main(ctx, a.options))
+}
+
+// uncounted is an error where there's nothing to print counts for, like a
+// file that couldn't be opened; GNU only reports those
+type uncounted struct {
+	error
+}
+
+func (u uncounted) Unwrap() error { return u.error }
+
+// countFile opens and counts one file.  A file that couldn't be counted has
+// the reason in its Err.
+func countFile(ctx *lib.Context, options Options, filename string) Results {
+	if filename == "-" && options.stdinLock != nil {
+		options.stdinLock.Lock()
+		defer options.stdinLock.Unlock()
+	}
+	_, in, err := ctx.ParseFiles(filename)
+	if err != nil {
+		return Results{Filename: filename, Err: uncounted{lib.OperandError("wc", filename, err)}}
+	}
+	defer in.Close()
+
+	if options.Decompress {
+		decompressed, err := decompress.NewReader(in)
+		if err != nil {
+			return Results{Filename: filename, Err: lib.OperandError("wc", filename, err)}
+		}
+		in = decompressed
+		defer in.Close()
+	}
+	results, err := count(options, in)
+	results.Filename = filename
+	if err != nil {
+		results.Err = lib.OperandError("wc", filename, err)
+	}
+	return results
+}
+
+// count in, splitting it between options.chunks workers if it's a big enough
+// regular file.  Only -c doesn't need to, as it's just the file's size.
+func count(options Options, in io.Reader) (Results, error) {
+	file, ok := in.(interface {
+		io.ReaderAt
+		io.Seeker
+		Stat() (os.FileInfo, error)
+	})
+	if !ok || options.chunks < 2 || options.selected() == 1 && options.GetBool(ByteFlag) {
+		return WordCount(options, in)
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return WordCount(options, in)
+	}
+	start, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return WordCount(options, in)
+	}
+	chunks := int((info.Size() - start) / minChunkSize)
+	if chunks > options.chunks {
+		chunks = options.chunks
+	}
+	if chunks < 2 {
+		return WordCount(options, in)
+	}
+	results, err := countChunks(options, file, start, info.Size(), chunks)
+	if err != nil {
+		return results, err
+	}
+	// Then anything added since, the way reading it would have
+	if _, err := file.Seek(info.Size(), io.SeekStart); err != nil {
+		return results, err
+	}
+	rest, err := WordCount(options, in)
+	results.Add(rest)
+	return results, err
 }
 
 // ReadFile from a chan and stream out results.
 // It was a closure over what the arguments are, but I want to pull it out to test
-func ReadFile(options Options, fnChan <-chan string, resChan chan<- Results, errChan chan<- error, wg *sync.WaitGroup) {
+func ReadFile(ctx *lib.Context, options Options, fnChan <-chan string, resChan chan<- Results, wg *sync.WaitGroup) {
+	defer wg.Done()
 	for filename := range fnChan {
-		_, in, err := lib.ParseFiles(filename)
-		if err != nil {
-			resChan <- Results{Filename: filename}
-			errChan <- err
+		resChan <- countFile(ctx, options, filename)
+	}
+}
+
+// opened reports if the file behind results could be opened at all; GNU
+// doesn't print counts for those that couldn't
+func opened(results Results) bool {
+	return !errors.As(results.Err, new(uncounted))
+}
+
+// readFiles0 sends each NUL terminated name in list to send as soon as it's
+// read, so the list can be as long as find -print0 likes.  Names that can't
+// be counted go to fail instead, with the reason.
+func readFiles0(list io.Reader, from string, send func(string), fail func(Results)) {
+	r := bufio.NewReader(list)
+	for n := 1; ; n++ {
+		name, err := r.ReadString(0)
+		if err != nil && err != io.EOF {
+			fail(Results{Filename: from, Err: uncounted{lib.OperandError("wc", from+": read error", err)}})
+			return
 		}
-		if filename != "-" {
-			defer in.Close()
+		if name == "" {
+			return
 		}
+		name = strings.TrimSuffix(name, "\x00")
+		switch {
+		case name == "":
+			reason := errors.New("invalid zero-length file name")
+			fail(Results{Err: uncounted{lib.OperandError("wc", fmt.Sprintf("%s:%d", from, n), reason)}})
+		case name == "-" && from == "-":
+			reason := errors.New("when reading file names from stdin, no file name of '-' allowed")
+			fail(Results{Filename: name, Err: uncounted{lib.OperandError("wc", "", reason)}})
+		default:
+			send(name)
+		}
+	}
+}
 
-		fmt.Println(filename)
-		results, err := WordCount(options, in)
-		results.Filename = filename
+// sizes is what numberWidth needs to know about the files
+type sizes struct {
+	files   int
+	total   uint
+	minimum int
+}
 
-		if err != nil {
-			errChan <- err
+// add filename to the sizes
+func (s *sizes) add(ctx *lib.Context, filename string) {
+	s.files++
+	var info os.FileInfo
+	var err error
+	if filename == "-" {
+		stdin, ok := ctx.Stdin.(interface{ Stat() (os.FileInfo, error) })
+		if !ok {
+			s.minimum = 7
+			return
 		}
-		resChan <- results
+		info, err = stdin.Stat()
+	} else {
+		info, err = os.Stat(ctx.Path(filename))
+	}
+	switch {
+	case err != nil:
+	case info.Mode().IsRegular():
+		s.total += uint(info.Size())
+	default:
+		s.minimum = 7
+	}
+}
+
+func (s sizes) width(options Options) int {
+	if s.files == 1 && options.selected() == 1 {
+		return 1
+	}
+	if options.Decompress && s.minimum < 7 {
+		// a compressed file's size says nothing of what's in it
+		s.minimum = 7
+	}
+	if width := int(approxLog10(s.total)); width > s.minimum {
+		return width
+	}
+	return s.minimum
+}
+
+// numberWidth is how wide GNU makes each count, which it works out before
+// counting anything so it can print each file as soon as it's done: wide
+// enough for the total size of the regular files, and at least 7 if any of
+// them aren't regular files, since there is no telling how big they are.
+// Just one count of one file isn't padded at all.
+func numberWidth(ctx *lib.Context, options Options, files []string) int {
+	s := sizes{minimum: 1}
+	for _, filename := range files {
+		s.add(ctx, filename)
+	}
+	return s.width(options)
+}
+
+// files0Width is numberWidth for a --files0-from list.  GNU only pads the
+// counts when the list is a regular file, which it reads all of up front;
+// this reads the list through once for the sizes and then rewinds it
+// instead, so it is never all in memory.  Any other list isn't padded.
+func files0Width(ctx *lib.Context, options Options, list io.Reader, from string) (int, error) {
+	file, ok := list.(interface {
+		io.Seeker
+		Stat() (os.FileInfo, error)
+	})
+	if !ok {
+		return 1, nil
+	}
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() {
+		return 1, nil
+	}
+	start, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 1, nil
+	}
+	s := sizes{minimum: 1}
+	readFiles0(list, from, func(name string) { s.add(ctx, name) }, func(Results) { s.files++ })
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return 1, lib.OperandError("wc", from, err)
+	}
+	return s.width(options), nil
+}
+
+// queue is where each file is in the list.  Results come back in whatever
+// order the workers finish, and a file can be in the list more than once,
+// so each filename has a queue of its places.
+type queue struct {
+	sync.Mutex
+	places map[string][]int
+	// n is how many files have been queued
+	n int
+}
+
+func (q *queue) push(filename string) {
+	q.Lock()
+	defer q.Unlock()
+	q.places[filename] = append(q.places[filename], q.n)
+	q.n++
+}
+
+// pop the place of filename's next result
+func (q *queue) pop(filename string) int {
+	q.Lock()
+	defer q.Unlock()
+	places := q.places[filename]
+	if len(places) == 1 {
+		delete(q.places, filename)
+	} else {
+		q.places[filename] = places[1:]
 	}
-	wg.Done()
+	return places[0]
 }
 
 // Main is the kickoff for the wc program.  so it can be compiled stand alone or as a subcommand
-// runs as many workers as CPUs.  this probably should be tunable at compile time
-func Main(options Options) error {
+// runs options.Jobs workers, and splits big files between any left over.
+// Each file's counts are printed in order as soon as they are known, then the total.
+// Files that fail are reported on the context's stderr when their turn comes, and
+// returned together at the end.
+// With Files0From set, the files are read from there instead of Files as the
+// workers go.
+func Main(ctx *lib.Context, options Options) error {
 	// First section is setting up filenames; makeing sure we know if
 	// We will read from stdin (if so, we just use one worker so its all sequential, as stdin can be
 	// read from multiple times; so order matters)
+	named := len(options.Files) > 0 || options.Files0From != ""
 	hasStdin := false
-	if len(options.Files) == 0 {
+	if !named {
 		options.Files = []string{"-"}
 		hasStdin = true
 	} else {
@@ -69,71 +367,112 @@ func Main(options Options) error {
 		}
 	}
 
-	// assemble the datastructures for collecting results
-	order := make(map[string]int)
-	r := make([]Results, len(options.Files))
-	for i, filename := range options.Files {
-		order[filename] = i
+	jobs := options.Jobs
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
 	}
-	workers := runtime.NumCPU()
-	if workers > len(options.Files) {
-		workers = len(options.Files)
-	}
-	if hasStdin {
-		workers = 1
+	workers := jobs
+	var width int
+	var list io.ReadCloser
+	if options.Files0From != "" {
+		// There's no knowing if the list names stdin until it's read, so
+		// whichever worker gets to it has it to itself.  It is usually
+		// empty by the time it's named twice, so the order those go in
+		// doesn't matter much.
+		options.stdinLock = &sync.Mutex{}
+		var err error
+		if _, list, err = ctx.ParseFiles(options.Files0From); err != nil {
+			err = lib.OperandError("wc", fmt.Sprintf("cannot open '%s' for reading", options.Files0From), err)
+			fmt.Fprintln(ctx.Stderr, err)
+			return err
+		}
+		defer list.Close()
+		if width, err = files0Width(ctx, options, list, options.Files0From); err != nil {
+			fmt.Fprintln(ctx.Stderr, err)
+			return err
+		}
+	} else {
+		width = numberWidth(ctx, options, options.Files)
+		if workers > len(options.Files) {
+			workers = len(options.Files)
+		}
+		if hasStdin {
+			workers = 1
+		}
 	}
 
+	// Whatever jobs aren't needed for a worker per file go to splitting the
+	// files up
+	options.chunks = jobs / workers
+
+	q := &queue{places: make(map[string][]int)}
 	fnChan := make(chan string)
 	resChan := make(chan Results)
-	errChan := make(chan error)
 	wg := sync.WaitGroup{}
-	errs := make(errors, 0)
+	errs := make(lib.Errors, 0)
 
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go ReadFile(options, fnChan, resChan, errChan, &wg)
+		go ReadFile(ctx, options, fnChan, resChan, &wg)
 	}
 
+	// Each file is queued before it's sent, so its place is known by the
+	// time its results come back
+	wg.Add(1)
 	go func() {
-		for _, filename := range options.Files {
+		defer wg.Done()
+		defer close(fnChan)
+		send := func(filename string) {
+			q.push(filename)
 			fnChan <- filename
 		}
-		close(fnChan)
+		if list != nil {
+			readFiles0(list, options.Files0From, send, func(results Results) {
+				q.push(results.Filename)
+				resChan <- results
+			})
+			return
+		}
+		for _, filename := range options.Files {
+			send(filename)
+		}
 	}()
 	go func() {
 		wg.Wait()
 		close(resChan)
-		close(errChan)
 	}()
 
-	var max uint
-readLoop:
-	for {
-		select {
-		case c, ok := <-resChan:
-			if !ok {
-				break readLoop
+	out := newPrinter(ctx.Stdout, options, width, named)
+	total := Results{}
+	pending := make(map[int]Results)
+	next := 0
+	for c := range resChan {
+		pending[q.pop(c.Filename)] = c
+
+		for results, ok := pending[next]; ok; results, ok = pending[next] {
+			delete(pending, next)
+			next++
+			if results.Err != nil {
+				fmt.Fprintln(ctx.Stderr, results.Err)
+				errs = append(errs, results.Err)
 			}
-			r[order[c.Filename]] = c
-			if c.Longest > max {
-				max = c.GetMax(options)
+			if opened(results) {
+				total.Add(results)
 			}
-		case err, ok := <-errChan:
-			if !ok {
-				break readLoop
+			if options.Total != TotalOnly {
+				out.file(results)
 			}
-			errs = append(errs, err)
 		}
+	}
 
+	if options.Total.print(q.n) {
+		out.total(total)
 	}
 
-	var err error
 	if len(errs) > 0 {
-		err = errs
+		return errs
 	}
-	resultsSet := ResultsSet{MaxNumber: max, Results: r}
-	fmt.Print(resultsSet.Printf(options))
-	return err
+	return nil
 }
 
 func BindFlagSet(wo *Options) *pflag.FlagSet {
@@ -143,27 +482,15 @@ func BindFlagSet(wo *Options) *pflag.FlagSet {
 	wcFS.BoolVarP(&wo.Words, WordFlag, "w", false, "Count words")
 	wcFS.BoolVarP(&wo.Characters, CharFlag, "m", false, "Count characters")
 	wcFS.BoolVarP(&wo.Longest, LongFlag, "L", false, "Print longest line length")
-	setUsage(wcFS)
+	wcFS.StringVar(&wo.Files0From, Files0Flag, "", "Read input from the files specified by\nNUL-terminated names in file `F`;\nIf F is - then read names from standard input")
+	wcFS.VarPF(modeFlag{&wo.Mode, ModePOSIX}, PosixFlag, "", "A word is anything between white space,\ncontrol characters included").NoOptDefVal = "true"
+	wcFS.VarPF(modeFlag{&wo.Mode, ModeUnicode}, UnicodeFlag, "", "Find words by the Unicode word boundaries\nrather than white space").NoOptDefVal = "true"
+	wcFS.Var(&wo.Total, TotalFlag, "When to print a line with total counts;\nWHEN can be: auto, always, only, never")
+	wcFS.IntVar(&wo.Jobs, JobsFlag, 0, "Count `N` files, or pieces of a big file, at once;\n0 is one per CPU")
+	wcFS.BoolVarP(&wo.Decompress, decompress.Flag, decompress.Shorthand, false, "Count what's in files compressed with gzip,\nbzip2, zlib, xz or zstd")
+	wcFS.IntVar(&wo.TabSize, TabFlag, DefaultTabSize, "Take tab stops for -L to be `N` columns apart")
+	wcFS.Var(&wo.Format, FormatFlag, "Print the counts as FORMAT: text, json, csv,\ntsv or nul")
+	wcFS.VarPF(nulFlag{&wo.Format}, "0", "0", "Print the counts as --format=nul").NoOptDefVal = "true"
+	lib.ShorthandOnly(wcFS, "0")
 	return wcFS
 }
-
-func setUsage(wcFS *pflag.FlagSet) {
-	wcFS.Usage = func() {
-
-		fmt.Fprint(wcFS.Output(), `Usage: wc [OPTION]... [FILE]...
-Print newline, word, and byte counts for each FILE, and a total line if
-more than one FILE is specified.  A word is a non-zero-length sequence of
-characters delimited by white space.
-
-With no FILE, or when FILE is -, read standard input.  - can appear multiple
-times in the list, and standard input will be read for each.
-
-If there is no standard input, wc will run as many workers as there are CPUs
-in your system and parallelize the work.
-
-The options below may be used to select which counts are printed, always in
-the following order: newline, word, character, byte, maximum line length.
-`)
-		wcFS.PrintDefaults()
-	}
-}
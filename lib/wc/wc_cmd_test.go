@@ -1,37 +1,42 @@
 package wc_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 
+	"gitlab.com/yarbelk/slimbox/lib"
 	"gitlab.com/yarbelk/slimbox/lib/wc"
 )
 
 func TestReadFileFailures(t *testing.T) {
 	fnChan := make(chan string)
 	resChan := make(chan wc.Results)
-	errChan := make(chan error)
 	wg := sync.WaitGroup{}
 	wg.Add(1)
-	go wc.ReadFile(wc.Options{Files: []string{"."}}, fnChan, resChan, errChan, &wg)
+	go wc.ReadFile(lib.OSContext(), wc.Options{Files: []string{"."}}, fnChan, resChan, &wg)
 	go func() {
 		wg.Wait()
 		close(resChan)
-		close(errChan)
 	}()
 
 	fnChan <- "."
 	close(fnChan)
-	err := <-errChan
 	res := <-resChan
+	err := res.Err
+	res.Err = nil
 
 	expected := wc.Results{Filename: "."}
 
-	if err.Error() != "read .: is a directory" {
+	if err.Error() != "wc: .: Is a directory" {
 		t.Errorf(err.Error())
 	}
 	if !reflect.DeepEqual(expected, res) {
@@ -40,3 +45,113 @@ func TestReadFileFailures(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestTotals(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a": "one two\nthree\n", "b": "four\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tests = []struct {
+		name     string
+		args     []string
+		expected string
+		status   int
+	}{
+		{"auto, one file", []string{"a"}, " 2  3 14 a\n", 0},
+		{"auto, two files", []string{"a", "b", "a"}, " 2  3 14 a\n 1  1  5 b\n 2  3 14 a\n 5  7 33 total\n", 0},
+		{"always", []string{"--total=always", "-l", "a"}, "2 a\n2 total\n", 0},
+		{"only", []string{"--total=only", "a", "b"}, " 3  4 19\n", 0},
+		{"never", []string{"--total", "never", "a", "b"}, " 2  3 14 a\n 1  1  5 b\n", 0},
+		{"missing files count for nothing", []string{"-w", "a", "missing"}, " 3 a\n 3 total\n", 1},
+		{"bad mode", []string{"--total=sometimes", "a"}, "", 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			ctx := &lib.Context{Stdout: stdout, Stderr: stderr, Dir: dir}
+			if status := lib.RunContext(ctx, "wc", tt.args); status != tt.status {
+				t.Errorf("expected exit %d, actual %d: %s", tt.status, status, stderr)
+			}
+			if stdout.String() != tt.expected {
+				t.Errorf("expected %q, actual %q", tt.expected, stdout)
+			}
+		})
+	}
+}
+
+func TestFiles0FromStreams(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("one two\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A plain io.Reader can't be read twice, so the list has to be streamed
+	list := strings.NewReader(strings.Repeat("a\x00", 10000))
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx := &lib.Context{Stdin: struct{ io.Reader }{list}, Stdout: stdout, Stderr: stderr, Dir: dir}
+
+	if status := lib.RunContext(ctx, "wc", []string{"-l", "--total=only", "--files0-from=-"}); status != 0 {
+		t.Fatalf("expected exit 0, actual %d: %s", status, stderr)
+	}
+	if expected := "20000\n"; stdout.String() != expected {
+		t.Errorf("expected %q, actual %q", expected, stdout)
+	}
+}
+
+// -z counts what's in compressed files, and the rest as they are
+func TestDecompress(t *testing.T) {
+	dir := t.TempDir()
+	compressed := &bytes.Buffer{}
+	zw := gzip.NewWriter(compressed)
+	zw.Write([]byte("one two\nthree\n"))
+	zw.Close()
+	for name, content := range map[string][]byte{"a.gz": compressed.Bytes(), "b": []byte("four\n"), "c.gz": []byte("\x1f\x8bnot really")} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx := &lib.Context{Stdout: stdout, Stderr: stderr, Dir: dir}
+
+	if status := lib.RunContext(ctx, "wc", []string{"-lwz", "a.gz", "b", "c.gz"}); status != lib.ExitFailure {
+		t.Errorf("expected exit %d, actual %d", lib.ExitFailure, status)
+	}
+	if expected := "      2       3 a.gz\n      1       1 b\n      0       0 c.gz\n      3       4 total\n"; stdout.String() != expected {
+		t.Errorf("expected %q, actual %q", expected, stdout)
+	}
+	if expected := "wc: c.gz: gzip: invalid header\n"; stderr.String() != expected {
+		t.Errorf("expected %q, actual %q", expected, stderr)
+	}
+}
+
+// Splitting a file between jobs has to count the same as one job does.  The
+// file is laid out so two jobs split it in the middle of a long line, and of
+// an ideographic space, and three split it between a word and a space.
+func TestJobsSplitFiles(t *testing.T) {
+	dir := t.TempDir()
+	size := 12 << 20
+	data := bytes.Repeat([]byte("ab cd\n"), size/6)
+	copy(data[size/2-600:], bytes.Repeat([]byte{'z'}, 1200))
+	copy(data[size/2-1:], "\u3000")
+	copy(data[size/3-1:], "x ")
+	if err := os.WriteFile(filepath.Join(dir, "big"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"-l"}, {"-w"}, {"-lwc"}, {"-L"}} {
+		var expected string
+		for _, jobs := range []string{"1", "2", "3"} {
+			stdout := &bytes.Buffer{}
+			ctx := &lib.Context{Stdout: stdout, Stderr: stdout, Dir: dir}
+			if status := lib.RunContext(ctx, "wc", append([]string{"--jobs", jobs, "big"}, args...)); status != 0 {
+				t.Fatalf("%s --jobs %s: exit %d: %s", args, jobs, status, stdout)
+			}
+			if jobs == "1" {
+				expected = stdout.String()
+			} else if stdout.String() != expected {
+				t.Errorf("%s --jobs %s: expected %q, actual %q", args, jobs, expected, stdout)
+			}
+		}
+	}
+}
@@ -1,56 +1,70 @@
 package wc_test
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
-	"gitlab.com/yarbelk/slimbox/lib/wc"
+	"gitlab.com/yarbelk/slimbox/lib"
 )
 
-func TestDefaultResultFormating(t *testing.T) {
-	resultset := wc.ResultsSet{MaxNumber: 10, Results: []wc.Results{{Bytes: 10, Characters: 5, Newlines: 2, Words: 2, Longest: 3, Filename: "testfile"}}}
-	expected := "   2   2  10  testfile\n"
-	if resultset.String() != expected {
-		t.Errorf("\n\t\texpected %+v\n\t\tactual   %+v", expected, resultset.String())
-	}
-}
-
-func TestFormating(t *testing.T) {
-	resultset := wc.ResultsSet{MaxNumber: 10, Results: []wc.Results{{Bytes: 10, Characters: 5, Newlines: 2, Words: 2, Longest: 3, Filename: "testfile"}}}
-	resultset2 := wc.ResultsSet{MaxNumber: 900, Results: []wc.Results{
-		{Bytes: 10, Characters: 5, Newlines: 2, Words: 2, Longest: 3, Filename: "testfile"},
-		{Bytes: 900, Characters: 50, Newlines: 22, Words: 18, Longest: 8, Filename: "testfile2"},
-	},
+func TestMachineFormats(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a b": "one two\nthree\n", "c,\"\n\td": "four\n", "a\tb": "five\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
 	}
 	var tests = []struct {
-		name       string
-		expected   string
-		Options    wc.Options
-		ResultsSet wc.ResultsSet
+		name     string
+		args     []string
+		expected string
+		status   int
 	}{
-		{
-			name:       "Standard",
-			expected:   "   2   2  10  testfile\n",
-			Options:    wc.Options{Files: []string{"testfile"}},
-			ResultsSet: resultset,
-		}, {
-			name:       "line and words",
-			expected:   "   2   2  testfile\n",
-			Options:    wc.Options{Files: []string{"testfile"}, Newlines: true, Words: true, NFlag: 2},
-			ResultsSet: resultset,
-		}, {
-			name:       "two lines, scaled correctly",
-			expected:   "    2    2    5   10    3  testfile\n   22   18   50  900    8  testfile2\n",
-			Options:    wc.Options{Files: []string{"testfile", "testfile2"}, Bytes: true, Characters: true, Longest: true, Newlines: true, Words: true, NFlag: 4},
-			ResultsSet: resultset2,
-		},
+		{"json", []string{"--format=json", "-lw", "a b", "c,\"\n\td"},
+			`{"lines":2,"words":3,"filename":"a b"}` + "\n" +
+				`{"lines":1,"words":1,"filename":"c,\"\n\td"}` + "\n" +
+				`{"lines":3,"words":4}` + "\n", 0},
+		{"csv", []string{"--format=csv", "-c", "a b", "c,\"\n\td"},
+			"bytes,filename,error\n14,a b,\n5,\"c,\"\"\n\td\",\n19,,\n", 0},
+		{"tsv", []string{"--format", "tsv", "-L", "c,\"\n\td"},
+			"max-line-length\tfilename\terror\n4\tc,\"\\n\\td\t\n", 0},
+		{"nul", []string{"-0", "-l", "c,\"\n\td"},
+			"lines\x00filename\x00error\x00" + "1\x00c,\"\n\td\x00\x00", 0},
+		{"nul keeps tabs in names", []string{"-0", "-w", "--total=always", "a\tb"},
+			"words\x00filename\x00error\x00" + "1\x00a\tb\x00\x00" + "1\x00\x00\x00", 0},
+		{"last format wins", []string{"-0", "--format=csv", "-l", "a b"}, "lines,filename,error\n2,a b,\n", 0},
+		{"errors", []string{"--format=json", "-l", "--total=never", "missing", "."},
+			`{"filename":"missing","error":"No such file or directory"}` + "\n" +
+				`{"lines":0,"filename":".","error":"Is a directory"}` + "\n", 1},
+		{"total only", []string{"--format=csv", "--total=only", "-m", "a b", "a b"}, "chars,filename,error\n28,,\n", 0},
+		{"bad format", []string{"--format=xml", "a b"}, "", 2},
 	}
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			actual := tt.ResultsSet.Printf(tt.Options)
-			if actual != tt.expected {
-				t.Errorf("given(%+v):\n\t\texpected\n%s\n\t\tactual:\n %s", tt.Options, tt.expected, actual)
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			ctx := &lib.Context{Stdout: stdout, Stderr: stderr, Dir: dir}
+			if status := lib.RunContext(ctx, "wc", tt.args); status != tt.status {
+				t.Errorf("expected exit %d, actual %d: %s", tt.status, status, stderr)
+			}
+			if stdout.String() != tt.expected {
+				t.Errorf("expected %q, actual %q", tt.expected, stdout)
 			}
 		})
 	}
 }
+
+// pflag already says which argument was invalid, so the format only lists
+// the ones that aren't
+func TestBadFormat(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	ctx := &lib.Context{Stdout: &bytes.Buffer{}, Stderr: stderr}
+	lib.RunContext(ctx, "wc", []string{"--format=xml"})
+	expected := "wc: invalid argument \"xml\" for \"--format\" flag: valid arguments are text, json, csv, tsv, nul\n"
+	if !strings.HasPrefix(stderr.String(), expected) {
+		t.Errorf("expected %q, actual %q", expected, stderr)
+	}
+}
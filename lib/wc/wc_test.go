@@ -1,9 +1,15 @@
 package wc_test
 
 import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
 
+	"gitlab.com/yarbelk/slimbox/lib/internal/corpus"
 	"gitlab.com/yarbelk/slimbox/lib/wc"
 )
 
@@ -27,12 +33,12 @@ func TestBasicTypesStdinStyle(t *testing.T) {
 		{"words on Lines: 3 c/b 2 w 2 l", wc.Results{Bytes: 3, Characters: 3, Words: 2, Newlines: 1, Longest: 1}, strings.NewReader("a\nb"), []string{}},
 		{"Tabs for Length: 3 c/b 2 w 2 l 1", wc.Results{Bytes: 3, Characters: 3, Words: 2, Newlines: 0, Longest: 9}, strings.NewReader("a\tb"), []string{}},
 		{"Multiple Tabs for Length: 3 c/b 2 w 2 l 2", wc.Results{Bytes: 4, Characters: 4, Words: 2, Newlines: 0, Longest: 17}, strings.NewReader("a\t\tb"), []string{}},
-		{"Vertical Tab for confusion: 3 c/b 2 w 2 l 2", wc.Results{Bytes: 3, Characters: 3, Words: 2, Newlines: 1, Longest: 2}, strings.NewReader("a\vb"), []string{}},
+		{"Vertical Tab is a space, not a line: 3 c/b 2 w 0 l 2", wc.Results{Bytes: 3, Characters: 3, Words: 2, Newlines: 0, Longest: 2}, strings.NewReader("a\vb"), []string{}},
 	}
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			options := wc.Options{Files: tt.args[:]}
+			options := wc.Options{Files: tt.args[:], Bytes: true, Characters: true, Newlines: true, Words: true, Longest: true, NFlag: 5}
 			actual, err := wc.WordCount(options, tt.given)
 			if err != nil {
 				t.Errorf("expected no error, actual %s", err)
@@ -60,7 +66,7 @@ func TestUnicodeStuff(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Log(arabic)
 			t.Log(chinese)
-			options := wc.Options{Files: tt.args[:]}
+			options := wc.Options{Files: tt.args[:], Bytes: true, Characters: true, Newlines: true, Words: true, Longest: true, NFlag: 5}
 			actual, err := wc.WordCount(options, tt.given)
 			if err != nil {
 				t.Errorf("expected no error, actual %s", err)
@@ -71,3 +77,215 @@ func TestUnicodeStuff(t *testing.T) {
 		})
 	}
 }
+
+// selected is just the counts options asks for
+func selected(options wc.Options, r wc.Results) wc.Results {
+	var s wc.Results
+	if options.GetBool(wc.LineFlag) {
+		s.Newlines = r.Newlines
+	}
+	if options.GetBool(wc.WordFlag) {
+		s.Words = r.Words
+	}
+	if options.GetBool(wc.ByteFlag) {
+		s.Bytes = r.Bytes
+	}
+	return s
+}
+
+// The fast paths for fewer counts have to agree with counting everything
+func TestCountPathsAgree(t *testing.T) {
+	all := wc.Options{Bytes: true, Characters: true, Newlines: true, Words: true, Longest: true, NFlag: 5}
+	inputs := map[string][]byte{
+		"controls":     []byte("a\x01b \x7f\n\x00\v c\f\r\t"),
+		"spaces":       []byte("one\u00a0two\u0085three\u2003four\u3000five"),
+		"invalid":      []byte("\xffab \xe4\xbd cd\xe4"),
+		"chinese":      []byte(chinese),
+		"arabic":       []byte(arabic),
+		"no final eol": []byte("a b\nc"),
+	}
+	for _, c := range corpus.All() {
+		inputs[c.Name] = c.Data
+	}
+	for name, input := range inputs {
+		locales := []wc.Options{{}, {Mode: wc.ModePOSIX}, {SingleByte: true}, {Mode: wc.ModePOSIX, SingleByte: true}}
+		if len(input) >= 1<<16 {
+			locales = locales[:1]
+		}
+		for _, locale := range locales {
+			all := all
+			all.Mode, all.SingleByte = locale.Mode, locale.SingleByte
+			expected, err := wc.WordCount(all, bytes.NewReader(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, options := range []wc.Options{
+				{},
+				{Words: true, NFlag: 1},
+				{Newlines: true, NFlag: 1},
+				{Bytes: true, NFlag: 1},
+				{Newlines: true, Bytes: true, NFlag: 2},
+			} {
+				options.Mode, options.SingleByte = locale.Mode, locale.SingleByte
+				readers := []io.Reader{bytes.NewReader(input)}
+				if len(input) < 1<<16 {
+					// A byte at a time splits every multibyte rune across reads
+					readers = append(readers, iotest.OneByteReader(bytes.NewReader(input)))
+				}
+				for _, in := range readers {
+					actual, err := wc.WordCount(options, in)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if selected(options, actual) != selected(options, expected) {
+						t.Errorf("%s %+v:\n\t\texpected %+v\n\t\tactual   %+v", name, options, selected(options, expected), selected(options, actual))
+					}
+				}
+			}
+		}
+	}
+}
+
+// -c on a regular file takes its size, from wherever it's been read up to
+func TestCountBytesOfFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("hello, world\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Read(make([]byte, 7)); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := wc.WordCount(wc.Options{Bytes: true, NFlag: 1}, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (wc.Results{Bytes: 6}); actual != expected {
+		t.Errorf("expected %+v, actual %+v", expected, actual)
+	}
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 13 {
+		t.Errorf("expected to be left at the end, actual %d", pos)
+	}
+}
+
+func TestModes(t *testing.T) {
+	var tests = []struct {
+		name     string
+		options  wc.Options
+		given    string
+		expected wc.Results
+	}{
+		{"control characters aren't words", wc.Options{}, "\x01\x02 a\n", wc.Results{Newlines: 1, Words: 1, Characters: 5}},
+		{"but they are to POSIX", wc.Options{Mode: wc.ModePOSIX}, "\x01\x02 a\n", wc.Results{Newlines: 1, Words: 2, Characters: 5}},
+		{"invalid bytes aren't characters", wc.Options{}, "a\xffb \xe4\xbd\n", wc.Results{Newlines: 1, Words: 1, Characters: 4}},
+		{"but they're in POSIX words", wc.Options{Mode: wc.ModePOSIX}, "a\xffb \xe4\xbd\n", wc.Results{Newlines: 1, Words: 2, Characters: 4}},
+		{"every byte is a character in the C locale", wc.Options{SingleByte: true}, "a\xffb \xe4\xbd\n", wc.Results{Newlines: 1, Words: 1, Characters: 7}},
+		{"no-break spaces split GNU words", wc.Options{}, "no\u00a0break\u2060joiner\u0085nel", wc.Results{Words: 3, Characters: 19}},
+		{"but not POSIX ones", wc.Options{Mode: wc.ModePOSIX}, "no\u00a0break\u2060joiner\u0085nel", wc.Results{Words: 1, Characters: 19}},
+		{"only newline is a line", wc.Options{}, "a\vb\fc\rd\u2028e\n", wc.Results{Newlines: 1, Words: 5, Characters: 10}},
+		{"unicode words", wc.Options{Mode: wc.ModeUnicode}, "can't stop, won't stop: 3.14!\n", wc.Results{Newlines: 1, Words: 5, Characters: 30}},
+		{"unicode CJK", wc.Options{Mode: wc.ModeUnicode}, chinese, wc.Results{Words: 4, Characters: 6}},
+		{"unicode Thai, with no dictionary", wc.Options{Mode: wc.ModeUnicode}, "สวัสดีครับ ไทย", wc.Results{Words: 10, Characters: 14}},
+		{"unicode invalid bytes", wc.Options{Mode: wc.ModeUnicode}, "a\xffb \xe4\xbd\n", wc.Results{Newlines: 1, Words: 2, Characters: 4}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			options.Newlines, options.Words, options.Characters, options.NFlag = true, true, true, 3
+			actual, err := wc.WordCount(options, strings.NewReader(tt.given))
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual.Bytes, actual.Longest = 0, 0
+			if actual != tt.expected {
+				t.Errorf("\n\t\texpected %+v\n\t\tactual   %+v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestWidths(t *testing.T) {
+	var tests = []struct {
+		name     string
+		options  wc.Options
+		given    string
+		expected uint
+	}{
+		{"wide characters", wc.Options{}, "漢字とカナ", 10},
+		{"fullwidth and ideographic space", wc.Options{}, "ＦＵＬＬ　x", 11},
+		{"combining marks", wc.Options{}, "café e⃝", 6},
+		{"zero width", wc.Options{}, "a​b⁠c­", 4},
+		{"tabs after wide characters", wc.Options{}, "漢字とカナ\tx", 17},
+		{"tab size", wc.Options{TabSize: 4}, "漢字\tx\ty", 13},
+		{"carriage return starts again", wc.Options{}, "longer line\rshort", 11},
+		{"and so does form feed", wc.Options{}, "form\ffeed and more", 13},
+		{"but not NEL", wc.Options{}, "ab\u0085cd", 4},
+		{"control characters take no room", wc.Options{}, "\x01a\vb\x7f", 2},
+		{"invalid bytes take no room", wc.Options{}, "a\xffb\xe4\xbd", 2},
+		{"emoji sequences are one emoji", wc.Options{}, "\U0001F468‍\U0001F469‍\U0001F467 \U0001F1EF\U0001F1F5", 5},
+		{"C locale", wc.Options{SingleByte: true}, "漢字\tx\xff", 9},
+		{"unicode mode", wc.Options{Mode: wc.ModeUnicode}, "漢字 é\tx", 9},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			options.Longest, options.Words, options.NFlag = true, true, 2
+			actual, err := wc.WordCount(options, strings.NewReader(tt.given))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual.Longest != tt.expected {
+				t.Errorf("expected %d, actual %d", tt.expected, actual.Longest)
+			}
+		})
+	}
+}
+
+// A grapheme cluster, or a rune, cut short by a read is measured once it's
+// whole
+func TestWidthsAcrossReads(t *testing.T) {
+	family := "\U0001F468\u200d\U0001F469\u200d\U0001F467"
+	for shift := 0; shift < 21; shift++ {
+		input := strings.Repeat("x", shift) + strings.Repeat(family+"漢", 20000)
+		expected := wc.Results{Longest: uint(shift + 80000), Characters: uint(shift + 120000)}
+		for _, mode := range []wc.Mode{wc.ModeGNU, wc.ModeUnicode} {
+			options := wc.Options{Longest: true, Characters: true, NFlag: 2, Mode: mode}
+			actual, err := wc.WordCount(options, strings.NewReader(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual.Bytes, actual.Words = 0, 0
+			if actual != expected {
+				t.Errorf("shifted %d, mode %d:\n\t\texpected %+v\n\t\tactual   %+v", shift, mode, expected, actual)
+			}
+		}
+	}
+}
+
+// Unicode words have to come out the same however the input is read, as
+// where a boundary goes can depend on what comes after it
+func TestUnicodeWordsAcrossReads(t *testing.T) {
+	options := wc.Options{Words: true, NFlag: 1, Mode: wc.ModeUnicode}
+	input := strings.Repeat("a.b 3,14 東京 🇯🇵🇯🇵 can't ", 20000)
+	expected, err := wc.WordCount(options, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected.Words != 5*20000 {
+		t.Errorf("expected %d words, actual %d", 5*20000, expected.Words)
+	}
+	actual, err := wc.WordCount(options, iotest.HalfReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.Words != expected.Words {
+		t.Errorf("read in halves: expected %d words, actual %d", expected.Words, actual.Words)
+	}
+}
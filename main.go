@@ -2,81 +2,41 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/pflag"
-	"github.com/yarbelk/slimbox/lib/falsy"
-	"github.com/yarbelk/slimbox/lib/truthy"
 	"gitlab.com/yarbelk/slimbox/lib"
-	"gitlab.com/yarbelk/slimbox/lib/cat"
-	"gitlab.com/yarbelk/slimbox/lib/wc"
 )
 
-var catOptions cat.CatOptions
+// The applets compiled in are imported in the applet_NAME.go files, so they
+// can be picked with build tags: see README.md
 
-var numberLines, numberNonBlankLines, help bool
-var lineNumber int = 1
-
-func version() {
-	os.Stderr.WriteString("Nice try - no versions yet\n")
-	os.Exit(0)
-}
-func init() {
-	// Complier seems to mess this up...
-	lib.RegisterFunction("true")
-	lib.RegisterFunction("false")
-}
-
-var _ func() = truthy.True
-var _ func() = falsy.False
-
-func main() {
-
-	// This is all placeholder until i migrate true, false and cat to pflag (fix argument ordering)
-	var verb string
-	if len(os.Args) > 1 {
-		verb = os.Args[1]
+// applet works out which function to run, and with what arguments.
+// When slimbox is invoked through a symlink (/bin/cat -> slimbox) the name it
+// was called by is the function; otherwise it is the first argument.
+func applet(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", nil
 	}
-	switch verb {
-	case "cat":
-		catOptions := cat.NewCatOptions()
-		catFS := cat.BindFlagSet(catOptions)
-		err := catFS.Parse(os.Args[2:])
-		if catOptions.Help {
-			catFS.Usage()
-			os.Exit(0)
-		}
-
-		if err == nil {
-			catOptions.Files = catFS.Args()
-			cat.RunCat(catOptions)
-			os.Exit(0)
-		}
+	if name := filepath.Base(args[0]); lib.RegisteredFunctions().Contains(name) {
+		return name, args[1:]
+	}
+	if len(args) > 1 {
+		return args[1], args[2:]
+	}
+	return "", nil
+}
 
-		os.Exit(1)
-	case "false":
-		falsy.False()
-	case "true":
-		truthy.True()
-	case "wc":
-		wcOptions := wc.Options{}
-		wcFS := wc.BindFlagSet(&wcOptions)
-		if err := wcFS.Parse(os.Args[2:]); err != nil {
-			if err != pflag.ErrHelp {
-				fmt.Fprint(os.Stderr, err)
-				wcFS.Usage()
-			}
-			os.Exit(1)
-		}
-		wcOptions.Files = wcFS.Args()[:]
-		if err := wc.Main(wcOptions); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
-		}
-	default:
-		fmt.Fprintf(os.Stderr, `
+func usage(w io.Writer) {
+	fmt.Fprintf(w, `
 Usage: slimbox [function [arguments]...]
    or: function [arguments]...
+   or: slimbox --install [-H] [-f] DIR
+   or: slimbox --list
+   or: slimbox --help [function]
+   or: slimbox --version
 
 slimbox is %s WIP.  Its also probably slower than any other compiled implementation.
 I mean; take alook at how I'm making that really bold; its silly.
@@ -84,6 +44,80 @@ The currently supported functions are:
 
 %s
 `, "\033[1mreally\033[0m", lib.RegisteredFunctions())
-		os.Exit(1)
+}
+
+// list the functions compiled in, with their summaries
+func list(ctx *lib.Context) {
+	width := 0
+	for _, fn := range lib.RegisteredFunctions() {
+		if len(fn) > width {
+			width = len(fn)
+		}
+	}
+	for _, fn := range lib.RegisteredFunctions() {
+		fmt.Fprintf(ctx.Stdout, "%-*s  %s\n", width, fn, lib.Summary(fn))
+	}
+}
+
+// slimbox handles being called as itself, rather than as one of its functions
+func slimbox(ctx *lib.Context, args []string) int {
+	var options installOptions
+	var installing, listFunctions, help, version bool
+	fs := pflag.NewFlagSet("slimbox", pflag.ContinueOnError)
+	fs.SetOutput(ctx.Stderr)
+	fs.BoolVar(&installing, "install", false, "create a link to slimbox for each function in the DIR given")
+	fs.BoolVarP(&options.Hardlink, "hardlink", "H", false, "with --install, make hard links instead of symbolic links")
+	fs.BoolVarP(&options.Force, "force", "f", false, "with --install, replace existing files")
+	fs.BoolVar(&listFunctions, "list", false, "list the functions compiled in")
+	fs.BoolVar(&help, "help", false, "display help for slimbox, or the function given, and exit")
+	fs.BoolVar(&version, "version", false, "output version information and exit")
+	fs.Usage = func() { usage(ctx.Stderr) }
+	if err := fs.Parse(args); err != nil {
+		if err != pflag.ErrHelp {
+			fmt.Fprintf(ctx.Stderr, "slimbox: %s\n", err)
+			usage(ctx.Stderr)
+		}
+		return lib.ExitUsage
+	}
+
+	switch {
+	case help && fs.NArg() > 0:
+		if err := lib.Help(ctx.Stdout, fs.Arg(0)); err != nil {
+			fmt.Fprintf(ctx.Stderr, "slimbox: %s\n", err)
+			return lib.ExitFailure
+		}
+		return lib.ExitSuccess
+	case help:
+		usage(ctx.Stdout)
+		return lib.ExitSuccess
+	case version:
+		fmt.Fprintf(ctx.Stdout, "slimbox %s\n", lib.Version())
+		return lib.ExitSuccess
+	case listFunctions:
+		list(ctx)
+		return lib.ExitSuccess
+	case !installing:
+		usage(ctx.Stderr)
+		return lib.ExitUsage
+	case fs.NArg() != 1:
+		fmt.Fprintf(ctx.Stderr, "slimbox: --install takes one DIR, not %d\n", fs.NArg())
+		usage(ctx.Stderr)
+		return lib.ExitUsage
+	}
+	options.Dir = fs.Arg(0)
+
+	binary, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "slimbox: %s\n", err)
+		return lib.ExitFailure
+	}
+	return install(ctx, options, binary)
+}
+
+func main() {
+	verb, args := applet(os.Args)
+	if lib.RegisteredFunctions().Contains(verb) {
+		os.Exit(lib.Run(verb, args))
 	}
+	os.Exit(slimbox(lib.OSContext(), os.Args[1:]))
 }
@@ -0,0 +1,35 @@
+#!/bin/sh
+# Run the benchmarks and keep the results, so optimisations can be compared.
+#
+#   scripts/bench.sh [BASE]
+#
+# Results go in bench/<commit>.txt.  Given the results of an earlier run as
+# BASE (a file, or a commit that has one in bench/), they are compared with
+# benchstat if you have it:
+#
+#   go install golang.org/x/perf/cmd/benchstat@latest
+#
+# BENCH (default .) picks benchmarks, COUNT (default 5) how many runs of each.
+set -eu
+
+cd "$(dirname "$0")/.."
+mkdir -p bench
+
+rev=$(git rev-parse --short HEAD)
+if ! git diff --quiet HEAD -- lib; then
+	rev="$rev-dirty"
+fi
+out="bench/$rev.txt"
+
+go test -run '^$' -bench "${BENCH:-.}" -benchmem -count "${COUNT:-5}" ./lib/... | tee "$out"
+echo "results in $out"
+
+if [ $# -gt 0 ]; then
+	base=$1
+	[ -f "$base" ] || base="bench/$(git rev-parse --short "$1").txt"
+	if command -v benchstat >/dev/null; then
+		benchstat "$base" "$out"
+	else
+		echo "benchstat not found; compare $base and $out by hand" >&2
+	fi
+fi